@@ -0,0 +1,273 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.neds.sh/matty/entain/api/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// maxBodySize bounds the request bodies read to build cache keys.
+	maxBodySize = 1 << 20
+
+	// maxResponseSize bounds the responses cached. Larger responses are
+	// served without an ETag, as they are written.
+	maxResponseSize = 4 << 20
+)
+
+var lookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "api",
+	Subsystem: "cache",
+	Name:      "lookups_total",
+	Help:      "Total number of response cache lookups, by result.",
+}, []string{"result"})
+
+// uncachedHeaders are response headers that are specific to a single request
+// and must never be replayed from the cache.
+var uncachedHeaders = []string{
+	"Date",
+	"Set-Cookie",
+	"X-Request-Id",
+	"Grpc-Metadata-X-Request-Id",
+}
+
+// Route marks requests as cacheable reads.
+type Route struct {
+	Method string
+	Path   string
+	// MaxAge is how long responses may be reused, both by clients through
+	// Cache-Control and by the gateway itself. Responses to routes with a
+	// zero MaxAge are revalidated on every request.
+	MaxAge time.Duration
+}
+
+// Cache is an HTTP middleware which caches responses to read routes, computes
+// strong ETags over them and answers conditional requests with 304s. Any
+// successful request with an unsafe method to a route which is not a read is
+// treated as a mutation and invalidates the cache.
+type Cache struct {
+	routes map[string]Route
+	vary   []string
+	store  *lru
+	now    func() time.Time
+}
+
+// New creates a new response cache holding up to maxEntries responses to the
+// given routes. Responses vary by the given request headers.
+func New(maxEntries int, routes []Route, vary ...string) *Cache {
+	c := &Cache{
+		routes: make(map[string]Route, len(routes)),
+		vary:   vary,
+		store:  newLRU(maxEntries),
+		now:    time.Now,
+	}
+
+	for _, route := range routes {
+		c.routes[route.Method+" "+route.Path] = route
+	}
+
+	return c
+}
+
+// Invalidate removes every cached response.
+func (c *Cache) Invalidate() {
+	c.store.purge()
+}
+
+// Middleware wraps next with the cache.
+func (c *Cache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, ok := c.routes[r.Method+" "+r.URL.Path]
+		if !ok {
+			c.serveUncached(next, w, r)
+			return
+		}
+
+		key, ok := c.key(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := c.now()
+		if e, ok := c.store.get(key, now); ok {
+			lookups.WithLabelValues("hit").Inc()
+			metrics.SetRoute(r.Context(), e.route)
+			c.write(w, r, route, e, now)
+
+			return
+		}
+
+		lookups.WithLabelValues("miss").Inc()
+
+		rec := newRecorder(w, maxResponseSize)
+		next.ServeHTTP(rec, r)
+
+		if rec.passedThrough {
+			return
+		}
+
+		if rec.status != http.StatusOK {
+			rec.replay(w)
+			return
+		}
+
+		e := &entry{
+			key:      key,
+			status:   rec.status,
+			header:   rec.header,
+			body:     rec.body.Bytes(),
+			etag:     etag(rec.body.Bytes()),
+			route:    metrics.Route(r.Context()),
+			storedAt: now,
+			expires:  now.Add(route.MaxAge),
+		}
+		for _, h := range uncachedHeaders {
+			e.header.Del(h)
+		}
+
+		if route.MaxAge > 0 {
+			c.store.add(e)
+		}
+
+		c.write(w, r, route, e, now)
+	})
+}
+
+// serveUncached serves requests to routes that are not cached, invalidating
+// the cache after successful mutations.
+func (c *Cache) serveUncached(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	if isSafe(r.Method) {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(sw, r)
+
+	if sw.status < http.StatusBadRequest {
+		c.Invalidate()
+	}
+}
+
+// write sends e in response to r, or a 304 when the client already holds it.
+func (c *Cache) write(w http.ResponseWriter, r *http.Request, route Route, e *entry, now time.Time) {
+	h := w.Header()
+	for k, vs := range e.header {
+		h[k] = append([]string(nil), vs...)
+	}
+
+	h.Set("ETag", e.etag)
+	h.Set("Cache-Control", fmt.Sprintf("max-age=%d", int(route.MaxAge.Seconds())))
+	h.Set("Age", strconv.Itoa(int(now.Sub(e.storedAt).Seconds())))
+	for _, v := range c.vary {
+		h.Add("Vary", v)
+	}
+
+	if etagMatches(r.Header.Get("If-None-Match"), e.etag) {
+		h.Del("Content-Length")
+		h.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+
+		return
+	}
+
+	h.Set("Content-Length", strconv.Itoa(len(e.body)))
+	w.WriteHeader(e.status)
+	_, _ = w.Write(e.body)
+}
+
+// key normalises r into a cache key, made of its method, path, sorted query
+// parameters, varying headers and canonicalised JSON body. It reports false
+// when the request cannot be cached.
+func (c *Cache) key(r *http.Request) (string, bool) {
+	var b strings.Builder
+
+	b.WriteString(r.Method)
+	b.WriteString(" ")
+	b.WriteString(r.URL.Path)
+	b.WriteString("?")
+	b.WriteString(r.URL.Query().Encode())
+
+	for _, h := range c.vary {
+		values := r.Header.Values(h)
+		// The Host header is moved out of the header map by net/http.
+		if strings.EqualFold(h, "Host") {
+			values = []string{r.Host}
+		}
+
+		b.WriteString("\n")
+		b.WriteString(strings.ToLower(h))
+		b.WriteString(": ")
+		b.WriteString(strings.Join(values, ","))
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return b.String(), true
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if err != nil || len(body) > maxBodySize {
+		return "", false
+	}
+
+	if len(bytes.TrimSpace(body)) > 0 {
+		// Re-encoding sorts object keys and drops insignificant whitespace.
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return "", false
+		}
+
+		canonical, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+
+		b.WriteString("\n\n")
+		b.Write(canonical)
+	}
+
+	return b.String(), true
+}
+
+// etag computes a strong entity tag over body.
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches tag.
+func etagMatches(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isSafe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	return false
+}
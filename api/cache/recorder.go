@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// recorder buffers a response so that it can be cached. Responses growing
+// beyond limit are too large to cache, so they are passed through to w
+// instead, as they are written.
+type recorder struct {
+	w           http.ResponseWriter
+	limit       int
+	header      http.Header
+	body        bytes.Buffer
+	status      int
+	wroteHeader bool
+	// passedThrough is set once the response is written to w.
+	passedThrough bool
+}
+
+func newRecorder(w http.ResponseWriter, limit int) *recorder {
+	return &recorder{w: w, limit: limit, header: make(http.Header), status: http.StatusOK}
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.wroteHeader {
+		return
+	}
+
+	r.status = code
+	r.wroteHeader = true
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+
+	if r.passedThrough {
+		return r.w.Write(b)
+	}
+
+	if r.body.Len()+len(b) > r.limit {
+		r.passedThrough = true
+		r.replay(r.w)
+
+		return r.w.Write(b)
+	}
+
+	return r.body.Write(b)
+}
+
+// Flush flushes responses that are passed through, leaving buffered ones be.
+func (r *recorder) Flush() {
+	if f, ok := r.w.(http.Flusher); ok && r.passedThrough {
+		f.Flush()
+	}
+}
+
+// replay writes the buffered response to w unchanged.
+func (r *recorder) replay(w http.ResponseWriter) {
+	h := w.Header()
+	for k, vs := range r.header {
+		h[k] = vs
+	}
+
+	w.WriteHeader(r.status)
+	_, _ = w.Write(r.body.Bytes())
+}
+
+// statusWriter captures the status code of a response as it is written.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush allows streaming responses to pass through the writer.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
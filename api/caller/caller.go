@@ -0,0 +1,230 @@
+package caller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"git.neds.sh/matty/entain/api/problem"
+	"git.neds.sh/matty/entain/common/claims"
+	"git.neds.sh/matty/entain/common/logging"
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// adminRole is the JWT role granting access to restricted content.
+const adminRole = "admin"
+
+// Caller describes who a request is made on behalf of.
+type Caller struct {
+	// Jurisdiction is the caller's region, e.g. "AU-NSW", or "" if unknown.
+	Jurisdiction string
+	// Admin callers see restricted content, along with why it is restricted.
+	Admin bool
+	// Brand is the brand the request is made through, or "" for none.
+	Brand string
+	// Subject is the subject of the caller's bearer token, or "" for
+	// anonymous callers.
+	Subject string
+}
+
+type callerKey struct{}
+
+// FromContext returns the caller carried by ctx.
+func FromContext(ctx context.Context) Caller {
+	c, _ := ctx.Value(callerKey{}).(Caller)
+	return c
+}
+
+// WithCaller returns a copy of ctx carrying c.
+func WithCaller(ctx context.Context, c Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, c)
+}
+
+// Config controls how callers are identified.
+type Config struct {
+	// Header is the request header carrying the caller's jurisdiction.
+	Header string
+	// Secret verifies HS256 signed bearer tokens. Tokens are ignored when it
+	// is empty.
+	Secret []byte
+
+	// BrandHeader is the request header carrying the brand.
+	BrandHeader string
+	// BrandHosts maps the hosts each brand is served on to the brand.
+	BrandHosts map[string]string
+	// DefaultBrand is the brand of requests that do not identify one.
+	DefaultBrand string
+	// Brands lists the known brands. Any brand is accepted when it is empty.
+	Brands []string
+}
+
+// tokenClaims are the JWT claims describing a caller.
+type tokenClaims struct {
+	Jurisdiction string   `json:"jurisdiction"`
+	Roles        []string `json:"roles"`
+	Brand        string   `json:"brand"`
+	jwt.RegisteredClaims
+}
+
+// Middleware identifies the caller of each request. The claims of a verified
+// bearer token take precedence over the jurisdiction header, and only tokens
+// may grant the admin role. The brand is taken from the token, the brand
+// header or the request's host, in that order, and a token issued for one
+// brand is refused on another brand's host.
+func Middleware(cfg Config, next http.Handler) http.Handler {
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
+	brands := make(map[string]bool, len(cfg.Brands))
+	for _, brand := range cfg.Brands {
+		brands[brand] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			c     Caller
+			brand string
+			err   error
+		)
+
+		if cfg.Header != "" {
+			if j := r.Header.Get(cfg.Header); j != "" {
+				if c.Jurisdiction, err = claims.ParseJurisdiction(j); err != nil {
+					writeProblem(w, r, problem.New(http.StatusBadRequest, fmt.Sprintf("%s: %s", cfg.Header, err)))
+					return
+				}
+			}
+		}
+
+		if cfg.BrandHeader != "" {
+			brand = strings.ToLower(strings.TrimSpace(r.Header.Get(cfg.BrandHeader)))
+		}
+		if brand == "" {
+			brand = cfg.BrandHosts[hostname(r.Host)]
+		}
+
+		if token := bearerToken(r); token != "" && len(cfg.Secret) > 0 {
+			var cl tokenClaims
+
+			if _, err := parser.ParseWithClaims(token, &cl, func(*jwt.Token) (interface{}, error) {
+				return cfg.Secret, nil
+			}); err != nil {
+				writeUnauthorized(w, r, "invalid bearer token")
+				return
+			}
+
+			if cl.Jurisdiction != "" {
+				if c.Jurisdiction, err = claims.ParseJurisdiction(cl.Jurisdiction); err != nil {
+					writeUnauthorized(w, r, "invalid bearer token: "+err.Error())
+					return
+				}
+			}
+
+			c.Subject = cl.Subject
+
+			for _, role := range cl.Roles {
+				c.Admin = c.Admin || role == adminRole
+			}
+
+			if cl.Brand != "" {
+				if brand != "" && brand != cl.Brand {
+					writeProblem(w, r, problem.New(http.StatusForbidden, fmt.Sprintf("bearer token is not valid for brand %q", brand)))
+					return
+				}
+
+				brand = cl.Brand
+			}
+		}
+
+		if brand == "" {
+			brand = cfg.DefaultBrand
+		}
+
+		if brand != "" {
+			if claims.ValidateBrand(brand) != nil || (len(brands) > 0 && !brands[brand]) {
+				writeProblem(w, r, problem.New(http.StatusBadRequest, fmt.Sprintf("unknown brand %q", brand)))
+				return
+			}
+		}
+
+		c.Brand = brand
+
+		next.ServeHTTP(w, r.WithContext(WithCaller(r.Context(), c)))
+	})
+}
+
+// UnaryClientInterceptor forwards the caller carried by the context to the
+// upstream gRPC server, replacing any caller metadata supplied by the client.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoingContext(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor is the streaming equivalent of UnaryClientInterceptor.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoingContext(ctx), desc, cc, method, opts...)
+	}
+}
+
+func outgoingContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+
+	delete(md, claims.JurisdictionKey)
+	delete(md, claims.AdminKey)
+	delete(md, claims.BrandKey)
+	delete(md, claims.SubjectKey)
+
+	c := FromContext(ctx)
+	if c.Jurisdiction != "" {
+		md.Set(claims.JurisdictionKey, c.Jurisdiction)
+	}
+	if c.Admin {
+		md.Set(claims.AdminKey, "true")
+	}
+	if c.Brand != "" {
+		md.Set(claims.BrandKey, c.Brand)
+	}
+	if c.Subject != "" {
+		md.Set(claims.SubjectKey, c.Subject)
+	}
+
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// bearerToken returns the bearer token in r's Authorization header, if any.
+func bearerToken(r *http.Request) string {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(parts[1])
+}
+
+// hostname returns host without its port, in lower case.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.ToLower(host)
+}
+
+// writeUnauthorized rejects a request with an invalid bearer token.
+func writeUnauthorized(w http.ResponseWriter, r *http.Request, detail string) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	writeProblem(w, r, problem.New(http.StatusUnauthorized, detail))
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, p *problem.Problem) {
+	p.Instance = r.URL.Path
+	p.RequestID = logging.RequestID(r.Context())
+
+	problem.Write(r.Context(), w, p)
+}
@@ -0,0 +1,219 @@
+package cors
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config describes the cross-origin requests allowed by the middleware.
+type Config struct {
+	// AllowedOrigins lists the origins allowed to make requests. An entry of
+	// "*" allows any origin, and entries may use a single wildcard for
+	// subdomains, e.g. "https://*.example.com".
+	AllowedOrigins []string
+	// AllowedMethods lists the methods allowed in cross-origin requests.
+	AllowedMethods []string
+	// AllowedHeaders lists the request headers allowed in cross-origin
+	// requests. An entry of "*" allows any header.
+	AllowedHeaders []string
+	// ExposedHeaders lists the response headers exposed to scripts.
+	ExposedHeaders []string
+	// AllowCredentials allows requests to include cookies and credentials. It
+	// may not be combined with an AllowedOrigins entry of "*".
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache preflight responses.
+	MaxAge time.Duration
+}
+
+// Middleware applies the CORS policy described by cfg to requests to next.
+// Preflight requests are answered directly and never reach next. When no
+// origins are allowed, next is returned unchanged. An error is returned if
+// cfg is unsafe.
+func Middleware(cfg Config, next http.Handler) (http.Handler, error) {
+	if len(cfg.AllowedOrigins) == 0 {
+		return next, nil
+	}
+
+	p, err := newPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			p.preflight(w, r, origin)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+
+		if p.allowOrigin(origin) {
+			p.setOrigin(h, origin)
+
+			if len(p.exposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", p.exposedHeaders)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	}), nil
+}
+
+// policy is the pre-processed form of a Config.
+type policy struct {
+	anyOrigin        bool
+	origins          map[string]bool
+	wildcards        [][2]string
+	methods          map[string]bool
+	allowedMethods   string
+	anyHeader        bool
+	headers          map[string]bool
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+}
+
+func newPolicy(cfg Config) (*policy, error) {
+	p := &policy{
+		origins:          make(map[string]bool),
+		methods:          make(map[string]bool),
+		headers:          make(map[string]bool),
+		allowedMethods:   strings.Join(cfg.AllowedMethods, ", "),
+		exposedHeaders:   strings.Join(cfg.ExposedHeaders, ", "),
+		allowCredentials: cfg.AllowCredentials,
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.ToLower(origin)
+
+		switch i := strings.Index(origin, "*"); {
+		case origin == "*":
+			// Credentials would otherwise be sent with requests from any
+			// site, by echoing back its origin.
+			if cfg.AllowCredentials {
+				return nil, errors.New("cors: credentials may not be allowed from any origin")
+			}
+
+			p.anyOrigin = true
+		case i >= 0:
+			p.wildcards = append(p.wildcards, [2]string{origin[:i], origin[i+1:]})
+		default:
+			p.origins[origin] = true
+		}
+	}
+
+	for _, method := range cfg.AllowedMethods {
+		p.methods[strings.ToUpper(method)] = true
+	}
+
+	for _, header := range cfg.AllowedHeaders {
+		if header == "*" {
+			p.anyHeader = true
+		}
+
+		p.headers[http.CanonicalHeaderKey(header)] = true
+	}
+
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+
+	return p, nil
+}
+
+// preflight answers a preflight request without invoking the wrapped handler.
+func (p *policy) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	requested := parseHeaderList(r.Header.Get("Access-Control-Request-Headers"))
+
+	if !p.allowOrigin(origin) || !p.methods[method] || !p.allowHeaders(requested) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	p.setOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", p.allowedMethods)
+
+	if len(requested) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+
+	if p.maxAge != "" {
+		h.Set("Access-Control-Max-Age", p.maxAge)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *policy) setOrigin(h http.Header, origin string) {
+	if p.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if p.allowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (p *policy) allowOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+
+	for _, w := range p.wildcards {
+		if len(origin) > len(w[0])+len(w[1]) && strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *policy) allowHeaders(requested []string) bool {
+	if p.anyHeader {
+		return true
+	}
+
+	for _, header := range requested {
+		if !p.headers[http.CanonicalHeaderKey(header)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseHeaderList splits a comma separated list of header names.
+func parseHeaderList(list string) []string {
+	var headers []string
+
+	for _, header := range strings.Split(list, ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			headers = append(headers, header)
+		}
+	}
+
+	return headers
+}
@@ -0,0 +1,54 @@
+package docs
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"strings"
+
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+// spec is the OpenAPI document generated from the racing protos by go
+// generate in ../proto.
+//
+//go:embed openapi.swagger.json
+var spec []byte
+
+// initializer configures Swagger UI to load the spec served by Spec.
+const initializer = `window.onload = function() {
+  window.ui = SwaggerUIBundle({
+    url: %q,
+    dom_id: "#swagger-ui",
+    deepLinking: true,
+    presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+    plugins: [SwaggerUIBundle.plugins.DownloadUrl],
+    layout: "StandaloneLayout"
+  });
+};
+`
+
+// Spec returns a handler serving the embedded OpenAPI document.
+func Spec() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(spec)
+	})
+}
+
+// UI returns a handler serving the embedded Swagger UI under prefix, which
+// must end in a slash, pointed at the OpenAPI document served at specURL.
+func UI(prefix, specURL string) http.Handler {
+	files := http.StripPrefix(prefix, http.FileServer(http.FS(swaggerFiles.FS)))
+	init := []byte(fmt.Sprintf(initializer, specURL))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.TrimPrefix(r.URL.Path, prefix) {
+		case "swagger-initializer.js":
+			w.Header().Set("Content-Type", "application/javascript")
+			_, _ = w.Write(init)
+		default:
+			files.ServeHTTP(w, r)
+		}
+	})
+}
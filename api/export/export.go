@@ -0,0 +1,204 @@
+package export
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"git.neds.sh/matty/entain/api/problem"
+	"git.neds.sh/matty/entain/api/proto/racing"
+	"git.neds.sh/matty/entain/api/query"
+	"git.neds.sh/matty/entain/common/logging"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/utilities"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// formatParam is the query parameter selecting the export format, taking
+// precedence over the Accept header.
+const formatParam = "format"
+
+// Handler serves race exports. The races are selected with the same query
+// parameters as GET /v1/races, and written in the format named by the format
+// query parameter or negotiated from the Accept header, defaulting to CSV.
+// Races are written as they are streamed from the racing service, so once
+// the first has arrived, failures can only be reported by aborting the
+// response.
+func Handler(client racing.RacingClient) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeProblem(w, r, problem.New(http.StatusMethodNotAllowed, ""))
+			return
+		}
+
+		f, p := negotiate(r)
+		if p != nil {
+			writeProblem(w, r, p)
+			return
+		}
+
+		values := r.URL.Query()
+		values.Del(formatParam)
+
+		req := &racing.ExportRacesRequest{}
+		if err := (query.FilterParser{}).Parse(req, values, utilities.NewDoubleArray(nil)); err != nil {
+			writeProblem(w, r, problem.FromStatus(status.New(codes.InvalidArgument, err.Error())))
+			return
+		}
+
+		stream, err := client.ExportRaces(r.Context(), req)
+		if err != nil {
+			writeProblem(w, r, problem.FromStatus(status.Convert(err)))
+			return
+		}
+
+		// Requests the racing service rejects fail on the first read, while
+		// the response can still describe why.
+		first, err := stream.Recv()
+		if err != nil && err != io.EOF {
+			writeProblem(w, r, problem.FromStatus(status.Convert(err)))
+			return
+		}
+
+		w.Header().Set("Content-Type", f.contentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": "races." + f.extension,
+		}))
+
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		next := func() (*racing.Race, error) {
+			if first != nil {
+				race := first
+				first = nil
+
+				return race, nil
+			}
+
+			if err == io.EOF {
+				return nil, err
+			}
+
+			return stream.Recv()
+		}
+
+		if err := f.write(w, next); err != nil {
+			logging.FromContext(r.Context()).Warn("failed writing race export", zap.String("format", f.name), zap.Error(err))
+
+			// The response is cut short rather than ending as if complete. The
+			// access log and metrics record the request as aborted.
+			panic(http.ErrAbortHandler)
+		}
+	})
+}
+
+// negotiate picks the export format for r, or describes why none fits.
+func negotiate(r *http.Request) (*format, *problem.Problem) {
+	if name := r.URL.Query().Get(formatParam); name != "" {
+		for _, f := range formats {
+			if f.name == name {
+				return f, nil
+			}
+		}
+
+		p := problem.FromStatus(status.New(codes.InvalidArgument, "unsupported export format"))
+		p.InvalidParams = []problem.InvalidParam{{Name: formatParam, Reason: "must be one of " + formatNames()}}
+
+		return nil, p
+	}
+
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return formats[0], nil
+	}
+
+	for _, mediaType := range acceptedMediaTypes(accept) {
+		for _, f := range formats {
+			if matches(mediaType, f) {
+				return f, nil
+			}
+		}
+	}
+
+	return nil, problem.New(http.StatusNotAcceptable, "exports are available as "+formatNames())
+}
+
+// acceptedMediaTypes returns the media ranges listed in Accept headers,
+// most preferred first, dropping those with a quality of zero.
+func acceptedMediaTypes(accept []string) []string {
+	type mediaRange struct {
+		mediaType string
+		quality   float64
+	}
+
+	var ranges []mediaRange
+
+	for _, header := range accept {
+		for _, value := range strings.Split(header, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+			if err != nil {
+				continue
+			}
+
+			quality := 1.0
+			if q, ok := params["q"]; ok {
+				if quality, err = strconv.ParseFloat(q, 64); err != nil {
+					continue
+				}
+			}
+
+			if quality > 0 {
+				ranges = append(ranges, mediaRange{mediaType, quality})
+			}
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+
+	mediaTypes := make([]string, len(ranges))
+	for i, r := range ranges {
+		mediaTypes[i] = r.mediaType
+	}
+
+	return mediaTypes
+}
+
+// matches reports whether the media range accepts f.
+func matches(mediaRange string, f *format) bool {
+	if mediaRange == "*/*" {
+		return true
+	}
+
+	for _, mediaType := range f.mediaTypes {
+		if mediaRange == mediaType || mediaRange == strings.SplitN(mediaType, "/", 2)[0]+"/*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func formatNames() string {
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = f.name
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, p *problem.Problem) {
+	p.Instance = r.URL.Path
+	p.RequestID = logging.RequestID(r.Context())
+
+	problem.Write(r.Context(), w, p)
+}
@@ -0,0 +1,196 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.neds.sh/matty/entain/api/proto/racing"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// format is an export file format.
+type format struct {
+	name        string
+	extension   string
+	contentType string
+	// mediaTypes are the media types accepted for the format.
+	mediaTypes []string
+	write      func(w io.Writer, next raceIterator) error
+}
+
+// raceIterator returns the next race to export, or io.EOF after the last.
+type raceIterator func() (*racing.Race, error)
+
+// formats lists the supported export formats, the first being the default.
+var formats = []*format{
+	{
+		name:        "csv",
+		extension:   "csv",
+		contentType: "text/csv; charset=utf-8",
+		mediaTypes:  []string{"text/csv"},
+		write:       writeCSV,
+	},
+	{
+		name:        "ndjson",
+		extension:   "ndjson",
+		contentType: "application/x-ndjson",
+		mediaTypes:  []string{"application/x-ndjson", "application/ndjson"},
+		write:       writeNDJSON,
+	},
+	{
+		name:        "ics",
+		extension:   "ics",
+		contentType: "text/calendar; charset=utf-8",
+		mediaTypes:  []string{"text/calendar"},
+		write:       writeICS,
+	},
+}
+
+// csvHeader matches the race card layout accepted by the racing import
+// command, so that exports can be imported again.
+var csvHeader = []string{"id", "meeting_id", "name", "number", "visible", "advertised_start_time"}
+
+func writeCSV(w io.Writer, next raceIterator) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for {
+		race, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := cw.Write([]string{
+			strconv.FormatInt(race.Id, 10),
+			strconv.FormatInt(race.MeetingId, 10),
+			race.Name,
+			strconv.FormatInt(race.Number, 10),
+			strconv.FormatBool(race.Visible),
+			race.AdvertisedStartTime.AsTime().Format(time.RFC3339),
+		}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+func writeNDJSON(w io.Writer, next raceIterator) error {
+	bw := bufio.NewWriter(w)
+	opts := protojson.MarshalOptions{EmitUnpopulated: true}
+
+	var line bytes.Buffer
+
+	for {
+		race, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		b, err := opts.Marshal(race)
+		if err != nil {
+			return err
+		}
+
+		// protojson output is deliberately unstable, so compact it to keep
+		// each race on a single predictable line.
+		line.Reset()
+		if err := json.Compact(&line, b); err != nil {
+			return err
+		}
+
+		line.WriteByte('\n')
+		if _, err := bw.Write(line.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// icsTimeFormat is the iCalendar UTC date-time format.
+const icsTimeFormat = "20060102T150405Z"
+
+// writeICS writes an iCalendar (RFC 5545) feed with an event per race,
+// starting at its advertised start time. Each event is stamped with when its
+// race last changed, so that the feed only changes along with the races.
+func writeICS(w io.Writer, next raceIterator) error {
+	bw := bufio.NewWriter(w)
+
+	writeICSLine(bw, "BEGIN:VCALENDAR")
+	writeICSLine(bw, "VERSION:2.0")
+	writeICSLine(bw, "PRODID:-//Entain//Racing API//EN")
+	writeICSLine(bw, "CALSCALE:GREGORIAN")
+	writeICSLine(bw, "METHOD:PUBLISH")
+	writeICSLine(bw, "X-WR-CALNAME:Races")
+
+	for {
+		race, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		writeICSLine(bw, "BEGIN:VEVENT")
+		writeICSLine(bw, fmt.Sprintf("UID:race-%d@racing.entain.com", race.Id))
+		writeICSLine(bw, "DTSTAMP:"+race.UpdatedAt.AsTime().UTC().Format(icsTimeFormat))
+		writeICSLine(bw, "DTSTART:"+race.AdvertisedStartTime.AsTime().UTC().Format(icsTimeFormat))
+		writeICSLine(bw, "SUMMARY:"+escapeICSText(fmt.Sprintf("R%d %s", race.Number, race.Name)))
+		writeICSLine(bw, "DESCRIPTION:"+escapeICSText(fmt.Sprintf("Race %d of meeting %d", race.Number, race.MeetingId)))
+		writeICSLine(bw, "END:VEVENT")
+	}
+
+	writeICSLine(bw, "END:VCALENDAR")
+
+	return bw.Flush()
+}
+
+// writeICSLine writes a content line, folding it so that no line is longer
+// than 75 octets without splitting a UTF-8 sequence.
+func writeICSLine(w *bufio.Writer, line string) {
+	limit := 75
+
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+
+		// Continuation lines start with a space, which counts to the limit.
+		limit = 74
+	}
+
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
+
+var icsTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`, "\r", "")
+
+// escapeICSText escapes a TEXT property value.
+func escapeICSText(s string) string {
+	return icsTextEscaper.Replace(s)
+}
@@ -0,0 +1,37 @@
+package grpcproxy
+
+import "fmt"
+
+// frame is an undecoded gRPC message.
+type frame struct {
+	payload []byte
+}
+
+// codec passes messages through unchanged, so that the proxy never needs to
+// know the request and response types of the methods it forwards.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	f, ok := v.(*frame)
+	if !ok {
+		return nil, fmt.Errorf("grpcproxy: unexpected message type %T", v)
+	}
+
+	return f.payload, nil
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	f, ok := v.(*frame)
+	if !ok {
+		return fmt.Errorf("grpcproxy: unexpected message type %T", v)
+	}
+
+	f.payload = append(f.payload[:0], data...)
+
+	return nil
+}
+
+// Name reports the codec as proto, which is what is on the wire.
+func (codec) Name() string {
+	return "proto"
+}
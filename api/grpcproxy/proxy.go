@@ -0,0 +1,145 @@
+package grpcproxy
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"git.neds.sh/matty/entain/common/claims"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// droppedMetadata lists incoming metadata that is not forwarded upstream,
+// either because it only concerns the downstream hop or because the client
+// interceptors on the upstream connection set it themselves. Caller metadata
+// is derived from the authenticated request, so clients may never supply it.
+var droppedMetadata = map[string]bool{
+	"connection":      true,
+	"content-length":  true,
+	"accept-encoding": true,
+	"x-grpc-web":      true,
+	"x-user-agent":    true,
+	"x-request-id":    true,
+	"traceparent":     true,
+	"tracestate":      true,
+	"baggage":         true,
+
+	claims.JurisdictionKey: true,
+	claims.AdminKey:        true,
+	claims.BrandKey:        true,
+	claims.SubjectKey:      true,
+}
+
+// New returns a gRPC server that forwards every call it receives to conn
+// without decoding the messages. Unary, client streaming, server streaming
+// and bidirectional calls are all supported.
+func New(conn *grpc.ClientConn) *grpc.Server {
+	p := &proxy{conn: conn}
+
+	return grpc.NewServer(
+		grpc.ForceServerCodec(codec{}),
+		grpc.UnknownServiceHandler(p.handle),
+	)
+}
+
+type proxy struct {
+	conn *grpc.ClientConn
+}
+
+func (p *proxy) handle(_ interface{}, serverStream grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(serverStream)
+	if !ok {
+		return status.Error(codes.Internal, "method missing from stream context")
+	}
+
+	ctx, cancel := context.WithCancel(serverStream.Context())
+	defer cancel()
+
+	ctx = metadata.NewOutgoingContext(ctx, forwardedMetadata(ctx))
+
+	clientStream, err := p.conn.NewStream(
+		ctx,
+		&grpc.StreamDesc{ServerStreams: true, ClientStreams: true},
+		method,
+		grpc.ForceCodec(codec{}),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Requests are forwarded in the background while responses are relayed
+	// here. A failure forwarding requests cancels the upstream call, which
+	// in turn ends the relay below.
+	go func() {
+		if err := forwardRequests(serverStream, clientStream); err != nil {
+			cancel()
+		}
+	}()
+
+	header, err := clientStream.Header()
+	if err == nil && len(header) > 0 {
+		if err := serverStream.SendHeader(header); err != nil {
+			return err
+		}
+	}
+
+	for {
+		f := &frame{}
+		if err := clientStream.RecvMsg(f); err != nil {
+			serverStream.SetTrailer(clientStream.Trailer())
+
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		if err := serverStream.SendMsg(f); err != nil {
+			return err
+		}
+	}
+}
+
+// forwardRequests copies request messages from the downstream caller to the
+// upstream server, half-closing the upstream stream once the caller has.
+func forwardRequests(serverStream grpc.ServerStream, clientStream grpc.ClientStream) error {
+	for {
+		f := &frame{}
+		if err := serverStream.RecvMsg(f); err != nil {
+			if err == io.EOF {
+				return clientStream.CloseSend()
+			}
+
+			return err
+		}
+
+		if err := clientStream.SendMsg(f); err != nil {
+			// io.EOF means the upstream call has already ended, and its
+			// status is reported by the response relay.
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+func forwardedMetadata(ctx context.Context) metadata.MD {
+	incoming, _ := metadata.FromIncomingContext(ctx)
+
+	md := metadata.MD{}
+	for key, values := range incoming {
+		if droppedMetadata[key] || strings.HasPrefix(key, ":") {
+			continue
+		}
+
+		md[key] = values
+	}
+
+	return md
+}
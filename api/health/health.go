@@ -0,0 +1,58 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// statusResponse is the body returned by the health endpoints.
+type statusResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Liveness returns a handler reporting that the gateway process is up. It
+// deliberately performs no upstream checks.
+func Liveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, http.StatusOK, statusResponse{Status: "ok"})
+	})
+}
+
+// Readiness returns a handler reporting whether the upstream gRPC service is
+// ready to serve traffic, as reported by its grpc.health.v1 service.
+func Readiness(client healthpb.HealthClient, service string, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			writeStatus(w, http.StatusServiceUnavailable, statusResponse{
+				Status: healthpb.HealthCheckResponse_UNKNOWN.String(),
+				Error:  err.Error(),
+			})
+
+			return
+		}
+
+		code := http.StatusOK
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			code = http.StatusServiceUnavailable
+		}
+
+		writeStatus(w, code, statusResponse{Status: resp.Status.String()})
+	})
+}
+
+func writeStatus(w http.ResponseWriter, code int, body statusResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -3,43 +3,318 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"git.neds.sh/matty/entain/api/cache"
+	"git.neds.sh/matty/entain/api/caller"
+	"git.neds.sh/matty/entain/api/cors"
+	"git.neds.sh/matty/entain/api/docs"
+	"git.neds.sh/matty/entain/api/export"
+	"git.neds.sh/matty/entain/api/grpcproxy"
+	"git.neds.sh/matty/entain/api/health"
+	"git.neds.sh/matty/entain/api/metrics"
+	"git.neds.sh/matty/entain/api/problem"
 	"git.neds.sh/matty/entain/api/proto/racing"
+	"git.neds.sh/matty/entain/api/query"
+	"git.neds.sh/matty/entain/common/logging"
+	"git.neds.sh/matty/entain/common/tlsconfig"
+	"git.neds.sh/matty/entain/common/tracing"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var (
-	apiEndpoint  = flag.String("api-endpoint", "localhost:8000", "API endpoint")
-	grpcEndpoint = flag.String("grpc-endpoint", "localhost:9000", "gRPC server endpoint")
+	apiEndpoint   = flag.String("api-endpoint", "localhost:8000", "API endpoint")
+	grpcEndpoint  = flag.String("grpc-endpoint", "localhost:9000", "gRPC server endpoint")
+	healthTimeout = flag.Duration("health-timeout", 2*time.Second, "Timeout for upstream readiness checks")
+	traceOutput   = flag.String("trace-output", "", "File to export trace spans to, or - for stdout (disabled when empty)")
+	logLevel      = flag.String("log-level", "info", "Minimum log level (debug, info, warn, error)")
+	cacheSize     = flag.Int("cache-size", 1000, "Maximum number of responses held in the response cache")
+	cacheMaxAge   = flag.Duration("cache-max-age", 5*time.Second, "How long race list responses may be cached")
+	grpcWeb       = flag.Bool("grpc-web", true, "Serve gRPC-Web requests for the racing service")
+
+	jurisdictionHeader = flag.String("jurisdiction-header", "X-Jurisdiction", "Request header carrying the caller's jurisdiction, e.g. AU-NSW (ignored when empty)")
+	jwtSecret          = flag.String("jwt-secret", "", "Secret verifying HS256 bearer tokens carrying jurisdiction, roles and brand claims (tokens ignored when empty)")
+	brandHeader        = flag.String("brand-header", "X-Brand", "Request header carrying the brand (ignored when empty)")
+	brandHosts         = flag.String("brand-hosts", "", "Comma separated host=brand pairs mapping the hosts brands are served on to the brand")
+	defaultBrand       = flag.String("default-brand", "", "Brand of requests that do not identify one (only shared content when empty)")
+	brands             = flag.String("brands", "", "Comma separated known brands (any brand accepted when empty)")
+
+	tlsCert           = flag.String("tls-cert", "", "PEM certificate served to HTTPS clients (plain HTTP when empty)")
+	tlsKey            = flag.String("tls-key", "", "PEM private key for -tls-cert")
+	grpcTLS           = flag.Bool("grpc-tls", false, "Connect to the gRPC server over TLS")
+	grpcTLSCA         = flag.String("grpc-tls-ca", "", "PEM CA bundle used to verify the gRPC server (system roots when empty)")
+	grpcTLSCert       = flag.String("grpc-tls-cert", "", "PEM client certificate presented to the gRPC server for mutual TLS")
+	grpcTLSKey        = flag.String("grpc-tls-key", "", "PEM private key for -grpc-tls-cert")
+	grpcTLSServerName = flag.String("grpc-tls-server-name", "", "Name the gRPC server certificate is verified against (endpoint host when empty)")
+	tlsReloadInterval = flag.Duration("tls-reload-interval", time.Minute, "How often TLS files are checked for changes (0 disables)")
+
+	corsAllowedOrigins   = flag.String("cors-allowed-origins", "", "Comma separated origins allowed to make cross-origin requests, * for any (disabled when empty)")
+	corsAllowedMethods   = flag.String("cors-allowed-methods", "GET,HEAD,POST,DELETE", "Comma separated methods allowed in cross-origin requests")
+	corsAllowedHeaders   = flag.String("cors-allowed-headers", "Accept,Content-Type,Authorization,If-None-Match,X-Request-ID,X-Jurisdiction,X-Brand,X-Grpc-Web,X-User-Agent,Grpc-Timeout", "Comma separated request headers allowed in cross-origin requests, * for any")
+	corsExposedHeaders   = flag.String("cors-exposed-headers", "ETag,Cache-Control,X-Request-ID", "Comma separated response headers exposed to cross-origin scripts")
+	corsAllowCredentials = flag.Bool("cors-allow-credentials", false, "Allow cross-origin requests to include credentials (not with * origins)")
+	corsMaxAge           = flag.Duration("cors-max-age", 10*time.Minute, "How long browsers may cache preflight responses")
 )
 
 func main() {
 	flag.Parse()
 
-	if err := run(); err != nil {
-		log.Printf("failed running api server: %s\n", err)
+	logger, err := logging.New(*logLevel)
+	if err != nil {
+		log.Fatalf("failed creating logger: %s\n", err)
+	}
+	defer logger.Sync()
+
+	zap.ReplaceGlobals(logger)
+
+	if err := run(logger); err != nil {
+		logger.Error("failed running api server", zap.Error(err))
 	}
 }
 
-func run() error {
+func run(logger *zap.Logger) error {
 	ctx := context.Background()
-	ctx, cancel := context.WithCancel(ctx)
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	mux := runtime.NewServeMux()
-	if err := racing.RegisterRacingHandlerFromEndpoint(
+	shutdownTracing, err := tracing.Init("api", *traceOutput)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
+	creds, err := transportCredentials(ctx)
+	if err != nil {
+		return err
+	}
+
+	conn, err := grpc.DialContext(
 		ctx,
-		mux,
 		*grpcEndpoint,
-		[]grpc.DialOption{grpc.WithInsecure()},
-	); err != nil {
+		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(
+			otelgrpc.UnaryClientInterceptor(),
+			logging.UnaryClientInterceptor(),
+			caller.UnaryClientInterceptor(),
+		),
+		grpc.WithChainStreamInterceptor(
+			otelgrpc.StreamClientInterceptor(),
+			logging.StreamClientInterceptor(),
+			caller.StreamClientInterceptor(),
+		),
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	mux := runtime.NewServeMux(
+		runtime.WithMetadata(metrics.RouteAnnotator),
+		runtime.WithErrorHandler(problem.ErrorHandler),
+		runtime.SetQueryParameterParser(query.FilterParser{}),
+	)
+	if err := racing.RegisterRacingHandler(ctx, mux, conn); err != nil {
+		return err
+	}
+
+	httpMux := http.NewServeMux()
+	httpMux.Handle("/healthz", health.Liveness())
+	httpMux.Handle("/readyz", health.Readiness(
+		healthpb.NewHealthClient(conn),
+		racing.Racing_ServiceDesc.ServiceName,
+		*healthTimeout,
+	))
+	httpMux.Handle("/metrics", promhttp.Handler())
+	httpMux.Handle("/openapi.json", docs.Spec())
+	httpMux.Handle("/docs/", docs.UI("/docs/", "/openapi.json"))
+	httpMux.Handle("/v1/races/export", export.Handler(racing.NewRacingClient(conn)))
+	httpMux.Handle("/", mux)
+
+	responseCache := cache.New(*cacheSize, []cache.Route{
+		{Method: http.MethodGet, Path: "/v1/races", MaxAge: *cacheMaxAge},
+		{Method: http.MethodPost, Path: "/v1/list-races", MaxAge: *cacheMaxAge},
+	}, varyHeaders()...)
+
+	// Cache hits are instrumented like any other request.
+	var handler http.Handler = metrics.Instrument(httpMux, responseCache.Middleware(httpMux))
+
+	// gRPC-Web calls are proxied to the racing service undecoded. They are
+	// routed ahead of the response cache, whose unsafe-method invalidation
+	// would otherwise fire on every call, so imports invalidate it
+	// themselves. CORS is left to cors.Middleware.
+	if *grpcWeb {
+		grpcWebServer := grpcweb.WrapServer(grpcproxy.New(conn))
+
+		webMux := http.NewServeMux()
+		webMux.HandleFunc("/"+racing.Racing_ServiceDesc.ServiceName+"/", grpcWebServer.HandleGrpcWebRequest)
+		webMux.HandleFunc("/"+racing.Racing_ServiceDesc.ServiceName+"/ImportRaces", func(w http.ResponseWriter, r *http.Request) {
+			defer responseCache.Invalidate()
+			grpcWebServer.HandleGrpcWebRequest(w, r)
+		})
+
+		handler = withGRPCWeb(metrics.Instrument(webMux, webMux), handler)
+	}
+
+	// Preflight requests are answered by the CORS middleware, so they never
+	// reach the gateway mux or the response cache.
+	corsConfig := cors.Config{
+		AllowedOrigins:   splitList(*corsAllowedOrigins),
+		AllowedMethods:   splitList(*corsAllowedMethods),
+		AllowedHeaders:   splitList(*corsAllowedHeaders),
+		ExposedHeaders:   splitList(*corsExposedHeaders),
+		AllowCredentials: *corsAllowCredentials,
+		MaxAge:           *corsMaxAge,
+	}
+
+	hosts, err := parseBrandHosts(*brandHosts)
+	if err != nil {
+		return err
+	}
+
+	callerConfig := caller.Config{
+		Header:       *jurisdictionHeader,
+		Secret:       []byte(*jwtSecret),
+		BrandHeader:  *brandHeader,
+		BrandHosts:   hosts,
+		DefaultBrand: *defaultBrand,
+		Brands:       splitList(*brands),
+	}
+
+	corsHandler, err := cors.Middleware(corsConfig, logging.Recovery(caller.Middleware(callerConfig, handler)))
+	if err != nil {
+		return err
+	}
+
+	handler = otelhttp.NewHandler(
+		logging.Middleware(logger, corsHandler),
+		"api",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+
+	server := &http.Server{Addr: *apiEndpoint, Handler: handler}
+
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	if *tlsCert != "" {
+		server.TLSConfig, err = tlsconfig.Server(ctx, tlsconfig.Options{
+			CertFile:       *tlsCert,
+			KeyFile:        *tlsKey,
+			ReloadInterval: *tlsReloadInterval,
+		})
+		if err != nil {
+			return err
+		}
+
+		logger.Info("API server listening", zap.String("endpoint", *apiEndpoint))
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		logger.Info("API server listening", zap.String("endpoint", *apiEndpoint))
+		err = server.ListenAndServe()
+	}
+
+	if err != http.ErrServerClosed {
 		return err
 	}
 
-	log.Printf("API server listening on: %s\n", *apiEndpoint)
+	return nil
+}
+
+// transportCredentials returns the credentials used to reach the gRPC
+// server, as configured by the gRPC TLS flags.
+func transportCredentials(ctx context.Context) (credentials.TransportCredentials, error) {
+	if !*grpcTLS {
+		return insecure.NewCredentials(), nil
+	}
+
+	cfg, err := tlsconfig.Client(ctx, tlsconfig.Options{
+		CertFile:       *grpcTLSCert,
+		KeyFile:        *grpcTLSKey,
+		CAFile:         *grpcTLSCA,
+		ServerName:     *grpcTLSServerName,
+		ReloadInterval: *tlsReloadInterval,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials.NewTLS(cfg), nil
+}
+
+// varyHeaders returns the request headers cached responses vary by. Races
+// are filtered by the caller, so responses also vary by what identifies them.
+func varyHeaders() []string {
+	vary := []string{"Accept", "Authorization"}
+	if *jurisdictionHeader != "" {
+		vary = append(vary, *jurisdictionHeader)
+	}
+	if *brandHeader != "" {
+		vary = append(vary, *brandHeader)
+	}
+	if *brandHosts != "" {
+		vary = append(vary, "Host")
+	}
+
+	return vary
+}
+
+// parseBrandHosts parses the host=brand pairs of the brand-hosts flag.
+func parseBrandHosts(list string) (map[string]string, error) {
+	hosts := make(map[string]string)
+
+	for _, pair := range splitList(list) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid brand host %q, expected host=brand", pair)
+		}
+
+		hosts[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.ToLower(strings.TrimSpace(parts[1]))
+	}
+
+	return hosts, nil
+}
+
+// withGRPCWeb sends gRPC-Web requests to grpcWeb and everything else to next.
+func withGRPCWeb(grpcWeb, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc-web") {
+			grpcWeb.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(list string) []string {
+	var values []string
+
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
 
-	return http.ListenAndServe(*apiEndpoint, mux)
+	return values
 }
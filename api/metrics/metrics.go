@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc/metadata"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "api",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Total number of HTTP requests handled by the gateway.",
+	}, []string{"route", "method", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "api",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of HTTP requests handled by the gateway.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	requestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "api",
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "Number of HTTP requests currently being handled by the gateway.",
+	})
+)
+
+type routeKey struct{}
+
+// Instrument wraps next, recording request counts, latencies and status
+// codes for every request. Requests are labelled with the mux pattern they
+// match, or with the gRPC method when they are routed through the gateway and
+// RouteAnnotator is installed on the runtime.ServeMux. Handlers that answer
+// requests without reaching the gateway, such as a cache, may label them with
+// SetRoute. Requests whose handler aborts the response are counted with the
+// code "aborted".
+func Instrument(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestsInFlight.Inc()
+		defer requestsInFlight.Dec()
+
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), routeKey{}, &route))
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		aborted := serve(next, rec, r)
+
+		code := strconv.Itoa(rec.status)
+		if aborted != nil {
+			code = "aborted"
+		}
+
+		requestsTotal.WithLabelValues(route, r.Method, code).Inc()
+		requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+
+		if aborted != nil {
+			panic(aborted)
+		}
+	})
+}
+
+// serve calls next, returning the value it panicked with, such as
+// http.ErrAbortHandler, if any.
+func serve(next http.Handler, w http.ResponseWriter, r *http.Request) (aborted interface{}) {
+	defer func() { aborted = recover() }()
+
+	next.ServeHTTP(w, r)
+
+	return nil
+}
+
+// Route returns the label of the request instrumented in ctx, or "" when it
+// is not instrumented.
+func Route(ctx context.Context) string {
+	if route, ok := ctx.Value(routeKey{}).(*string); ok {
+		return *route
+	}
+
+	return ""
+}
+
+// SetRoute relabels the request instrumented in ctx.
+func SetRoute(ctx context.Context, route string) {
+	if r, ok := ctx.Value(routeKey{}).(*string); ok && route != "" {
+		*r = route
+	}
+}
+
+// RouteAnnotator labels gateway requests with the gRPC method they were
+// routed to. It is intended to be installed with runtime.WithMetadata and
+// never adds any metadata itself.
+func RouteAnnotator(ctx context.Context, _ *http.Request) metadata.MD {
+	route, ok := ctx.Value(routeKey{}).(*string)
+	if !ok {
+		return nil
+	}
+
+	if method, ok := runtime.RPCMethod(ctx); ok {
+		*route = method
+	}
+
+	return nil
+}
+
+// statusRecorder captures the status code written by the wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Flush allows streaming responses to pass through the recorder.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
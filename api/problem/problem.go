@@ -0,0 +1,120 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"git.neds.sh/matty/entain/common/logging"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ContentType is the media type of RFC 7807 problem details.
+const ContentType = "application/problem+json"
+
+// typeBaseURI prefixes the problem type URIs derived from error reasons.
+const typeBaseURI = "https://api.entain.com/problems/"
+
+// Problem is an RFC 7807 problem details object, extended with the gRPC
+// status information relevant to clients.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	Code          string            `json:"code,omitempty"`
+	Reason        string            `json:"reason,omitempty"`
+	Domain        string            `json:"domain,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	InvalidParams []InvalidParam    `json:"invalid_params,omitempty"`
+	RequestID     string            `json:"request_id,omitempty"`
+}
+
+// InvalidParam describes a single invalid request field.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// New builds problem details for an HTTP status code, for errors raised by
+// the gateway itself rather than the upstream service.
+func New(code int, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(code),
+		Status: code,
+		Detail: detail,
+	}
+}
+
+// FromStatus builds the problem details describing st.
+func FromStatus(st *status.Status) *Problem {
+	code := runtime.HTTPStatusFromCode(st.Code())
+
+	p := &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(code),
+		Status: code,
+		Detail: st.Message(),
+		Code:   st.Code().String(),
+	}
+
+	// Unknown errors may carry arbitrary upstream text, so never echo it.
+	if st.Code() == codes.Unknown {
+		p.Detail = ""
+	}
+
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			p.Type = typeBaseURI + strings.ReplaceAll(strings.ToLower(d.Reason), "_", "-")
+			p.Reason = d.Reason
+			p.Domain = d.Domain
+			p.Metadata = d.Metadata
+		case *errdetails.BadRequest:
+			for _, v := range d.FieldViolations {
+				p.InvalidParams = append(p.InvalidParams, InvalidParam{Name: v.Field, Reason: v.Description})
+			}
+		}
+	}
+
+	return p
+}
+
+// ErrorHandler renders errors as application/problem+json responses. It is
+// designed to be installed with runtime.WithErrorHandler.
+func ErrorHandler(ctx context.Context, mux *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	p := FromStatus(status.Convert(err))
+	p.Instance = r.URL.Path
+	p.RequestID = logging.RequestID(r.Context())
+
+	if md, ok := runtime.ServerMetadataFromContext(ctx); ok {
+		for k, vs := range md.HeaderMD {
+			for _, v := range vs {
+				w.Header().Add(fmt.Sprintf("%s%s", runtime.MetadataHeaderPrefix, k), v)
+			}
+		}
+	}
+
+	Write(ctx, w, p)
+}
+
+// Write sends p as an application/problem+json response.
+func Write(ctx context.Context, w http.ResponseWriter, p *Problem) {
+	w.Header().Del("Trailer")
+	w.Header().Del("Transfer-Encoding")
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		logging.FromContext(ctx).Warn("failed writing problem response", zap.Error(err))
+	}
+}
@@ -0,0 +1,96 @@
+package query
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/utilities"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// filterField is the name of the request field holding list filters.
+const filterField = "filter"
+
+// FilterParser is a runtime.QueryParameterParser which binds query parameters
+// to request fields by their field path, like the default parser, and also
+// binds unqualified parameters to the request's filter, so that
+// ?meeting_ids=1 is equivalent to ?filter.meeting_ids=1.
+type FilterParser struct{}
+
+var _ runtime.QueryParameterParser = FilterParser{}
+
+// Parse populates msg from values. Parameters whose path starts with one of
+// the elements in filter are bound from the path and are skipped.
+func (FilterParser) Parse(msg proto.Message, values url.Values, filter *utilities.DoubleArray) error {
+	for key, vs := range values {
+		path := resolve(msg.ProtoReflect().Descriptor(), key)
+		if filter.HasCommonPrefix(path) {
+			continue
+		}
+
+		fd := lookup(msg.ProtoReflect().Descriptor(), path)
+		if fd == nil {
+			// Unrelated parameters, such as cache busters, are ignored.
+			continue
+		}
+
+		if !fd.IsList() && len(vs) > 1 {
+			return fmt.Errorf("too many values for field %q: %s", key, strings.Join(vs, ", "))
+		}
+
+		for _, v := range vs {
+			if err := runtime.PopulateFieldFromPath(msg, strings.Join(path, "."), v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolve returns the field path a query parameter binds to.
+func resolve(md protoreflect.MessageDescriptor, key string) []string {
+	path := strings.Split(key, ".")
+	if len(path) > 1 || field(md, key) != nil {
+		return path
+	}
+
+	if f := field(md, filterField); f != nil && f.Message() != nil && !f.IsList() && field(f.Message(), key) != nil {
+		return []string{filterField, key}
+	}
+
+	return path
+}
+
+// lookup returns the descriptor of the field at path, or nil when there is none.
+func lookup(md protoreflect.MessageDescriptor, path []string) protoreflect.FieldDescriptor {
+	var fd protoreflect.FieldDescriptor
+
+	for i, name := range path {
+		if md == nil {
+			return nil
+		}
+
+		if fd = field(md, name); fd == nil {
+			return nil
+		}
+
+		if i < len(path)-1 {
+			md = fd.Message()
+		}
+	}
+
+	return fd
+}
+
+// field looks a field up by its proto or JSON name.
+func field(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if fd := md.Fields().ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+
+	return md.Fields().ByJSONName(name)
+}
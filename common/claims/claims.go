@@ -0,0 +1,43 @@
+package claims
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Metadata keys the gateway forwards the caller it authenticated to the
+// racing service with.
+const (
+	JurisdictionKey = "x-caller-jurisdiction"
+	AdminKey        = "x-caller-admin"
+	BrandKey        = "x-caller-brand"
+	SubjectKey      = "x-caller-subject"
+)
+
+// jurisdictionPattern matches ISO 3166-1 country codes, optionally followed by
+// an ISO 3166-2 subdivision, e.g. "AU" or "AU-NSW".
+var jurisdictionPattern = regexp.MustCompile(`^[A-Z]{2}(-[A-Z0-9]{1,3})?$`)
+
+// brandPattern matches brand IDs, such as "ladbrokes" or "neds".
+var brandPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// ParseJurisdiction normalises a jurisdiction code, returning an error unless
+// it is a country or a country subdivision.
+func ParseJurisdiction(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !jurisdictionPattern.MatchString(code) {
+		return "", fmt.Errorf("invalid jurisdiction %q, expected a code such as AU or AU-NSW", code)
+	}
+
+	return code, nil
+}
+
+// ValidateBrand returns an error unless brand is a valid brand ID.
+func ValidateBrand(brand string) error {
+	if !brandPattern.MatchString(brand) {
+		return fmt.Errorf("invalid brand %q, expected lower case letters, digits and dashes", brand)
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+module git.neds.sh/matty/entain/common
+
+go 1.16
+
+require (
+	github.com/felixge/httpsnoop v1.0.2
+	github.com/google/uuid v1.3.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	go.uber.org/zap v1.19.1
+	golang.org/x/sys v0.0.0-20210809222454-d867a43fc93e // indirect
+	google.golang.org/grpc v1.40.0
+)
@@ -0,0 +1,134 @@
+package logging
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor assigns each RPC a request ID, exposes a request
+// scoped logger through FromContext and writes an access log entry once the
+// RPC completes.
+func UnaryServerInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, reqLogger := prepareContext(ctx, logger, info.FullMethod)
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		logAccess(reqLogger, start, err)
+
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is the streaming equivalent of UnaryServerInterceptor.
+func StreamServerInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, reqLogger := prepareContext(ss.Context(), logger, info.FullMethod)
+		start := time.Now()
+
+		err := handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+
+		logAccess(reqLogger, start, err)
+
+		return err
+	}
+}
+
+// UnaryRecoveryInterceptor converts panics raised by handlers into Internal
+// errors, logging the panic and its stack trace.
+func UnaryRecoveryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recoverPanic(ctx, r)
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamRecoveryInterceptor is the streaming equivalent of UnaryRecoveryInterceptor.
+func StreamRecoveryInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recoverPanic(ss.Context(), r)
+			}
+		}()
+
+		return handler(srv, ss)
+	}
+}
+
+func prepareContext(ctx context.Context, logger *zap.Logger, method string) (context.Context, *zap.Logger) {
+	ctx, id := incomingRequestID(ctx)
+
+	fields := []zap.Field{
+		zap.String("request_id", id),
+		zap.String("grpc.method", method),
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields, zap.String("trace_id", sc.TraceID().String()))
+	}
+
+	reqLogger := logger.With(fields...)
+
+	// Echo the request ID back to the caller so that it can be correlated.
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDKey, id))
+
+	return WithLogger(ctx, reqLogger), reqLogger
+}
+
+func logAccess(logger *zap.Logger, start time.Time, err error) {
+	code := status.Code(err)
+
+	level := zapcore.InfoLevel
+	switch code {
+	case codes.OK, codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists,
+		codes.PermissionDenied, codes.Unauthenticated, codes.FailedPrecondition, codes.OutOfRange:
+	default:
+		level = zapcore.ErrorLevel
+	}
+
+	fields := []zap.Field{
+		zap.String("grpc.code", code.String()),
+		zap.Duration("duration", time.Since(start)),
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+
+	if ce := logger.Check(level, "rpc completed"); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+func recoverPanic(ctx context.Context, r interface{}) error {
+	FromContext(ctx).Error("recovered from panic",
+		zap.Any("panic", r),
+		zap.ByteString("stack", debug.Stack()),
+	)
+
+	return status.Error(codes.Internal, "internal error")
+}
+
+// wrappedStream overrides the context of a grpc.ServerStream.
+type wrappedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *wrappedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/felixge/httpsnoop"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Middleware assigns every request an ID, accepting one supplied through the
+// X-Request-ID header, exposes a request scoped logger through FromContext
+// and writes an access log entry once the request completes, or is aborted.
+func Middleware(logger *zap.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := newRequestID(r.Header.Get(RequestIDHeader))
+		w.Header().Set(RequestIDHeader, id)
+
+		fields := []zap.Field{zap.String("request_id", id)}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			fields = append(fields, zap.String("trace_id", sc.TraceID().String()))
+		}
+
+		reqLogger := logger.With(fields...)
+
+		ctx := WithRequestID(r.Context(), id)
+		ctx = WithLogger(ctx, reqLogger)
+
+		// Handlers abort responses they cannot complete by panicking, which
+		// is logged and then passed on to the server.
+		var aborted interface{}
+
+		m := httpsnoop.CaptureMetricsFn(w, func(w http.ResponseWriter) {
+			defer func() { aborted = recover() }()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+
+		level, msg := zapcore.InfoLevel, "request completed"
+		switch {
+		case aborted != nil:
+			level, msg = zapcore.ErrorLevel, "request aborted"
+		case m.Code >= http.StatusInternalServerError:
+			level = zapcore.ErrorLevel
+		}
+
+		if ce := reqLogger.Check(level, msg); ce != nil {
+			ce.Write(
+				zap.String("http.method", r.Method),
+				zap.String("http.path", r.URL.Path),
+				zap.String("http.query", r.URL.RawQuery),
+				zap.Int("http.status", m.Code),
+				zap.Int64("http.response_size", m.Written),
+				zap.String("http.remote_addr", r.RemoteAddr),
+				zap.String("http.user_agent", r.UserAgent()),
+				zap.Duration("duration", m.Duration),
+			)
+		}
+
+		if aborted != nil {
+			panic(aborted)
+		}
+	})
+}
+
+// Recovery converts panics raised by next into 500 responses, logging the
+// panic and its stack trace.
+func Recovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+
+				FromContext(r.Context()).Error("recovered from panic",
+					zap.Any("panic", p),
+					zap.ByteString("stack", debug.Stack()),
+				)
+
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// RequestIDHeader is the HTTP header used to accept and return request IDs.
+	RequestIDHeader = "X-Request-ID"
+
+	// RequestIDKey is the gRPC metadata key used to propagate request IDs upstream.
+	RequestIDKey = "x-request-id"
+)
+
+// maxRequestIDLength bounds caller supplied request IDs, so that they cannot
+// be used to bloat logs and metadata.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID returns the request ID carried by ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithRequestID returns a copy of ctx carrying id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// newRequestID returns the caller supplied request ID when it is usable, or
+// a freshly generated one otherwise.
+func newRequestID(supplied string) string {
+	if supplied != "" && len(supplied) <= maxRequestIDLength {
+		return supplied
+	}
+
+	return uuid.NewString()
+}
+
+// incomingRequestID returns a copy of ctx carrying the request ID from the
+// incoming metadata, generating a new one when the caller did not supply a
+// usable one.
+func incomingRequestID(ctx context.Context) (context.Context, string) {
+	var supplied string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(RequestIDKey); len(values) > 0 {
+			supplied = values[0]
+		}
+	}
+
+	id := newRequestID(supplied)
+
+	return WithRequestID(ctx, id), id
+}
+
+// UnaryClientInterceptor forwards the request ID carried by the context to
+// the upstream gRPC server.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoingContext(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor is the streaming equivalent of UnaryClientInterceptor.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoingContext(ctx), desc, cc, method, opts...)
+	}
+}
+
+func outgoingContext(ctx context.Context) context.Context {
+	if id := RequestID(ctx); id != "" {
+		return metadata.AppendToOutgoingContext(ctx, RequestIDKey, id)
+	}
+
+	return ctx
+}
@@ -0,0 +1,277 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Options describes where TLS material is loaded from.
+type Options struct {
+	// CertFile and KeyFile hold the PEM encoded certificate and key this
+	// side presents. They are optional for clients, which only present a
+	// certificate for mutual TLS.
+	CertFile string
+	KeyFile  string
+	// CAFile holds PEM encoded CA certificates. For servers it enables
+	// mutual TLS, requiring clients to present a certificate signed by one
+	// of them; for clients it replaces the system roots.
+	CAFile string
+	// ServerName overrides the name clients verify the server against.
+	ServerName string
+	// ReloadInterval is how often the files are checked for changes
+	// (0 disables reloading).
+	ReloadInterval time.Duration
+}
+
+// Server returns a TLS configuration for a server. Certificates and client
+// CAs are reloaded from disk while ctx is live.
+func Server(ctx context.Context, opts Options) (*tls.Config, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("tls: server requires a certificate and key")
+	}
+
+	s, err := newStore(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	go s.watch(ctx, opts.ReloadInterval)
+
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, _ := s.get()
+		return cert, nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+	}
+
+	if opts.CAFile != "" {
+		// The client CA pool can only be swapped per handshake, by handing
+		// out a fresh configuration for each client.
+		cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			_, pool := s.get()
+
+			return &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: getCertificate,
+				ClientAuth:     tls.RequireAndVerifyClientCert,
+				ClientCAs:      pool,
+				NextProtos:     []string{"h2", "http/1.1"},
+			}, nil
+		}
+	}
+
+	return cfg, nil
+}
+
+// Client returns a TLS configuration for a client. The client certificate
+// and CAs, if any, are reloaded from disk while ctx is live.
+func Client(ctx context.Context, opts Options) (*tls.Config, error) {
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("tls: client certificate and key must be given together")
+	}
+
+	s, err := newStore(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.CertFile != "" || opts.CAFile != "" {
+		go s.watch(ctx, opts.ReloadInterval)
+	}
+
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: opts.ServerName,
+	}
+
+	if opts.CAFile != "" {
+		// RootCAs is fixed once the configuration is in use, so the server
+		// is verified against the current pool by hand instead.
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			_, pool := s.get()
+
+			return verifyServer(cs, opts.ServerName, pool)
+		}
+	}
+
+	if opts.CertFile != "" {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := s.get()
+			return cert, nil
+		}
+	}
+
+	return cfg, nil
+}
+
+// verifyServer verifies the certificate chain presented by a server against
+// roots, as the TLS client would have. The server is verified against
+// serverName, or else the name sent to it, which is never an IP address.
+func verifyServer(cs tls.ConnectionState, serverName string, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tls: server presented no certificate")
+	}
+
+	if serverName == "" {
+		serverName = cs.ServerName
+	}
+
+	if serverName == "" {
+		return errors.New("tls: a server name is required to verify the server")
+	}
+
+	verifyOpts := x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+
+	for _, cert := range cs.PeerCertificates[1:] {
+		verifyOpts.Intermediates.AddCert(cert)
+	}
+
+	_, err := cs.PeerCertificates[0].Verify(verifyOpts)
+
+	return err
+}
+
+// store holds the current TLS material loaded from disk.
+type store struct {
+	opts Options
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+func newStore(opts Options) (*store, error) {
+	s := &store{opts: opts}
+
+	if _, err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *store) get() (*tls.Certificate, *x509.CertPool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.cert, s.pool
+}
+
+// watch reloads the TLS material every interval until ctx is done. Failed
+// reloads keep the previous material, so a half-written rotation does not
+// take the server down.
+func (s *store) watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := s.reload()
+			if err != nil {
+				zap.L().Warn("failed reloading tls material", zap.Error(err))
+				continue
+			}
+
+			if reloaded {
+				zap.L().Info("reloaded tls material",
+					zap.String("cert_file", s.opts.CertFile),
+					zap.String("ca_file", s.opts.CAFile),
+				)
+			}
+		}
+	}
+}
+
+// reload loads the TLS material if any of the files changed since the last
+// load, reporting whether it did.
+func (s *store) reload() (bool, error) {
+	modTime, err := latestModTime(s.opts.CertFile, s.opts.KeyFile, s.opts.CAFile)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	unchanged := !s.modTime.IsZero() && !modTime.After(s.modTime)
+	s.mu.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	var cert *tls.Certificate
+	if s.opts.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(s.opts.CertFile, s.opts.KeyFile)
+		if err != nil {
+			return false, fmt.Errorf("tls: loading key pair: %w", err)
+		}
+
+		cert = &pair
+	}
+
+	var pool *x509.CertPool
+	if s.opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(s.opts.CAFile)
+		if err != nil {
+			return false, fmt.Errorf("tls: reading ca file: %w", err)
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return false, fmt.Errorf("tls: no certificates found in %s", s.opts.CAFile)
+		}
+	}
+
+	s.mu.Lock()
+	s.cert, s.pool, s.modTime = cert, pool, modTime
+	s.mu.Unlock()
+
+	return true, nil
+}
+
+// latestModTime returns the most recent modification time of the given
+// files, ignoring empty names.
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("tls: %w", err)
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
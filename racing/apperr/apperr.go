@@ -0,0 +1,127 @@
+package apperr
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+	"google.golang.org/grpc/codes"
+)
+
+// Domain identifies the racing service in google.rpc.ErrorInfo details.
+const Domain = "racing.entain.com"
+
+// Reasons reported in google.rpc.ErrorInfo details.
+const (
+	ReasonInvalidArgument  = "INVALID_ARGUMENT"
+	ReasonNotFound         = "NOT_FOUND"
+	ReasonPermissionDenied = "PERMISSION_DENIED"
+	ReasonDeadlineExceeded = "DEADLINE_EXCEEDED"
+	ReasonCanceled         = "CANCELED"
+	ReasonStorageBusy      = "STORAGE_BUSY"
+	ReasonStorageFailure   = "STORAGE_FAILURE"
+	ReasonInternal         = "INTERNAL"
+)
+
+// FieldViolation describes a single invalid request field.
+type FieldViolation struct {
+	// Field is the path to the offending field, e.g. "filter.meeting_ids[0]".
+	Field string
+	// Description explains why the field is invalid.
+	Description string
+}
+
+// Error is a domain error carrying everything needed to build a rich gRPC
+// status. Message is returned to callers, while the wrapped cause is only
+// ever logged.
+type Error struct {
+	Code       codes.Code
+	Reason     string
+	Message    string
+	Metadata   map[string]string
+	Violations []FieldViolation
+
+	cause error
+}
+
+// New creates a new domain error.
+func New(code codes.Code, reason, message string) *Error {
+	return &Error{Code: code, Reason: reason, Message: message}
+}
+
+// Wrap creates a new domain error caused by err.
+func Wrap(err error, code codes.Code, reason, message string) *Error {
+	return &Error{Code: code, Reason: reason, Message: message, cause: err}
+}
+
+// InvalidArgument creates a domain error describing the given field violations.
+func InvalidArgument(violations ...FieldViolation) *Error {
+	e := New(codes.InvalidArgument, ReasonInvalidArgument, "request contains invalid fields")
+	e.Violations = violations
+
+	return e
+}
+
+// NotFound creates a domain error for a missing resource.
+func NotFound(resource string, id interface{}) *Error {
+	e := New(codes.NotFound, ReasonNotFound, fmt.Sprintf("%s %v not found", resource, id))
+	e.Metadata = map[string]string{"resource": resource, "id": fmt.Sprint(id)}
+
+	return e
+}
+
+// PermissionDenied creates a domain error for a caller that may not perform
+// an action.
+func PermissionDenied(message string) *Error {
+	return New(codes.PermissionDenied, ReasonPermissionDenied, message)
+}
+
+// WithMetadata attaches a key/value pair to the error's ErrorInfo.
+func (e *Error) WithMetadata(key, value string) *Error {
+	if e.Metadata == nil {
+		e.Metadata = make(map[string]string)
+	}
+
+	e.Metadata[key] = value
+
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.cause)
+	}
+
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// FromRepository classifies an error returned by a repository, so that
+// storage details are never leaked to callers.
+func FromRepository(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return Wrap(err, codes.DeadlineExceeded, ReasonDeadlineExceeded, "query deadline exceeded")
+	case errors.Is(err, context.Canceled):
+		return Wrap(err, codes.Canceled, ReasonCanceled, "request cancelled")
+	case errors.Is(err, sql.ErrNoRows):
+		return Wrap(err, codes.NotFound, ReasonNotFound, "resource not found")
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked) {
+		return Wrap(err, codes.Unavailable, ReasonStorageBusy, "storage is temporarily unavailable")
+	}
+
+	return Wrap(err, codes.Internal, ReasonStorageFailure, "failed to access storage")
+}
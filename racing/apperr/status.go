@@ -0,0 +1,94 @@
+package apperr
+
+import (
+	"context"
+	"errors"
+
+	"git.neds.sh/matty/entain/common/logging"
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Status converts err into a gRPC status. Domain errors carry an ErrorInfo
+// detail, plus a BadRequest detail when they describe field violations.
+// Errors which are already gRPC statuses are returned unchanged, and any other
+// error is reported as Internal without exposing its message.
+func Status(err error) *status.Status {
+	if err == nil {
+		return nil
+	}
+
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+
+	var appErr *Error
+	if !errors.As(err, &appErr) {
+		appErr = Wrap(err, codes.Internal, ReasonInternal, "internal error")
+	}
+
+	var details []proto.Message
+	if len(appErr.Violations) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, v := range appErr.Violations {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Description,
+			})
+		}
+
+		details = append(details, badRequest)
+	}
+
+	details = append(details, &errdetails.ErrorInfo{
+		Reason:   appErr.Reason,
+		Domain:   Domain,
+		Metadata: appErr.Metadata,
+	})
+
+	st := status.New(appErr.Code, appErr.Message)
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
+	}
+
+	return st
+}
+
+// UnaryServerInterceptor converts errors returned by handlers into rich gRPC
+// statuses, logging the underlying cause of server side failures.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, convert(ctx, err)
+		}
+
+		return resp, nil
+	}
+}
+
+// StreamServerInterceptor is the streaming equivalent of UnaryServerInterceptor.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := handler(srv, ss); err != nil {
+			return convert(ss.Context(), err)
+		}
+
+		return nil
+	}
+}
+
+func convert(ctx context.Context, err error) error {
+	st := Status(err)
+
+	switch st.Code() {
+	case codes.Internal, codes.Unknown, codes.Unavailable, codes.DataLoss:
+		logging.FromContext(ctx).Error("request failed", zap.Error(err))
+	}
+
+	return st.Err()
+}
@@ -0,0 +1,25 @@
+package audit
+
+import "context"
+
+// Actors recorded for changes not made on behalf of a caller.
+const (
+	SystemSeed = "system:seed"
+	Anonymous  = "anonymous"
+)
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx recording changes as made by actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns who changes made with ctx are attributed to.
+func Actor(ctx context.Context) string {
+	if actor, _ := ctx.Value(actorKey{}).(string); actor != "" {
+		return actor
+	}
+
+	return Anonymous
+}
@@ -0,0 +1,94 @@
+package caller
+
+import (
+	"context"
+	"net"
+
+	"git.neds.sh/matty/entain/common/claims"
+	"git.neds.sh/matty/entain/common/logging"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// TrustLoopback lets callers on loopback peers vouch for their caller without
+// mutual TLS, e.g. for a gateway on the same host. Any local process may then
+// claim to act for any caller, including administrators, so it is off by
+// default.
+var TrustLoopback bool
+
+// Caller describes who a request is made on behalf of.
+type Caller struct {
+	// Jurisdiction is the caller's region, e.g. "AU-NSW", or "" if unknown.
+	Jurisdiction string
+	// Admin callers see restricted content, along with why it is restricted.
+	Admin bool
+	// Brand is the brand the request is made through. Callers without a
+	// brand only see content shared by every brand.
+	Brand string
+	// Subject identifies the authenticated caller, e.g. a user ID, or is ""
+	// for anonymous callers.
+	Subject string
+}
+
+// FromContext returns the caller of the incoming request in ctx. Requests
+// from untrusted peers are made by anonymous callers, whatever their metadata
+// claims.
+func FromContext(ctx context.Context) Caller {
+	var c Caller
+
+	if !trusted(ctx) {
+		return c
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if vs := md.Get(claims.JurisdictionKey); len(vs) > 0 && vs[0] != "" {
+		j, err := claims.ParseJurisdiction(vs[0])
+		if err != nil {
+			logging.FromContext(ctx).Warn("ignoring invalid caller jurisdiction", zap.Error(err))
+		}
+
+		c.Jurisdiction = j
+	}
+
+	if vs := md.Get(claims.BrandKey); len(vs) > 0 && vs[0] != "" {
+		if err := claims.ValidateBrand(vs[0]); err != nil {
+			logging.FromContext(ctx).Warn("ignoring invalid caller brand", zap.Error(err))
+		} else {
+			c.Brand = vs[0]
+		}
+	}
+
+	if vs := md.Get(claims.SubjectKey); len(vs) > 0 {
+		c.Subject = vs[0]
+	}
+
+	if vs := md.Get(claims.AdminKey); len(vs) > 0 {
+		c.Admin = vs[0] == "true"
+	}
+
+	return c
+}
+
+// trusted reports whether the peer of the incoming request in ctx may vouch
+// for its caller.
+func trusted(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+		return true
+	}
+
+	if !TrustLoopback {
+		return false
+	}
+
+	addr, ok := p.Addr.(*net.TCPAddr)
+
+	return ok && addr.IP.IsLoopback()
+}
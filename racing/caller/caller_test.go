@@ -0,0 +1,48 @@
+package caller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+
+	"git.neds.sh/matty/entain/common/claims"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestFromContextTrust(t *testing.T) {
+	var (
+		loopback = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+		remote   = &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 50000}
+		mutual   = credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}}
+	)
+
+	for _, test := range []struct {
+		name          string
+		peer          *peer.Peer
+		trustLoopback bool
+		want          bool
+	}{
+		{"loopback", &peer.Peer{Addr: loopback}, false, false},
+		{"trusted loopback", &peer.Peer{Addr: loopback}, true, true},
+		{"remote with loopback trusted", &peer.Peer{Addr: remote}, true, false},
+		{"mutual TLS", &peer.Peer{Addr: remote, AuthInfo: mutual}, false, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(trust bool) { TrustLoopback = trust }(TrustLoopback)
+			TrustLoopback = test.trustLoopback
+
+			ctx := metadata.NewIncomingContext(
+				peer.NewContext(context.Background(), test.peer),
+				metadata.Pairs(claims.SubjectKey, "admin-1", claims.AdminKey, "true"),
+			)
+
+			if got := FromContext(ctx).Admin; got != test.want {
+				t.Errorf("got admin %v, want %v", got, test.want)
+			}
+		})
+	}
+}
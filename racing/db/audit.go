@@ -0,0 +1,292 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"git.neds.sh/matty/entain/common/logging"
+	"git.neds.sh/matty/entain/racing/audit"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Audited operations.
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+// auditTimeLayout is a fixed width layout, so that stored times order
+// lexically.
+const auditTimeLayout = "2006-01-02T15:04:05.000000Z"
+
+// AuditFilter selects audit events. Zero fields match any event.
+type AuditFilter struct {
+	RaceID int64
+	Actor  string
+	Start  time.Time
+	End    time.Time
+}
+
+// AuditRepo provides read access to the audit log of race changes. Events
+// are written by the races repository, in the same transaction as the
+// changes they record.
+type AuditRepo interface {
+	// List will return the events the brand may see, newest first.
+	List(ctx context.Context, brand string, filter AuditFilter, limit int) ([]*racing.AuditEvent, error)
+}
+
+type auditRepo struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+}
+
+// NewAuditRepo creates a new audit repository. Each query is bounded by
+// queryTimeout, unless it is zero.
+func NewAuditRepo(db *sql.DB, queryTimeout time.Duration) AuditRepo {
+	return &auditRepo{db: db, queryTimeout: queryTimeout}
+}
+
+func (r *auditRepo) List(ctx context.Context, brand string, filter AuditFilter, limit int) (events []*racing.AuditEvent, err error) {
+	ctx, done := startQuery(ctx, auditList, r.queryTimeout)
+	defer func() { done(err) }()
+
+	var (
+		clauses = []string{"brand IN ('', ?)"}
+		args    = []interface{}{brand}
+	)
+
+	if filter.RaceID != 0 {
+		clauses = append(clauses, "race_id = ?")
+		args = append(args, filter.RaceID)
+	}
+
+	if filter.Actor != "" {
+		clauses = append(clauses, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+
+	if !filter.Start.IsZero() {
+		clauses = append(clauses, "occurred_at >= ?")
+		args = append(args, filter.Start.UTC().Format(auditTimeLayout))
+	}
+
+	if !filter.End.IsZero() {
+		clauses = append(clauses, "occurred_at < ?")
+		args = append(args, filter.End.UTC().Format(auditTimeLayout))
+	}
+
+	query := getRaceQueries()[auditList] + " WHERE " + strings.Join(clauses, " AND ") + " ORDER BY id DESC LIMIT ?"
+
+	rows, err := r.db.QueryContext(ctx, query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			event         racing.AuditEvent
+			occurredAt    string
+			before, after sql.NullString
+		)
+
+		if err := rows.Scan(&event.Id, &event.RaceId, &event.Operation, &event.Actor, &event.RequestId, &occurredAt, &before, &after); err != nil {
+			return nil, err
+		}
+
+		t, err := time.Parse(auditTimeLayout, occurredAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if event.OccurredAt, err = ptypes.TimestampProto(t); err != nil {
+			return nil, err
+		}
+
+		if event.Before, err = unmarshalSnapshot(before); err != nil {
+			return nil, err
+		}
+
+		if event.After, err = unmarshalSnapshot(after); err != nil {
+			return nil, err
+		}
+
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
+
+// createAuditSchema creates the audit log, which triggers keep append-only.
+func createAuditSchema(ctx context.Context, db *sql.DB) error {
+	for _, statement := range []string{
+		`CREATE TABLE IF NOT EXISTS audit_events (id INTEGER PRIMARY KEY AUTOINCREMENT, race_id INTEGER NOT NULL, operation TEXT NOT NULL, actor TEXT NOT NULL, request_id TEXT NOT NULL, brand TEXT NOT NULL, occurred_at TEXT NOT NULL, before TEXT, after TEXT)`,
+		`CREATE INDEX IF NOT EXISTS audit_events_race_id ON audit_events (race_id)`,
+		`CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events BEGIN SELECT RAISE(ABORT, 'audit events are append-only'); END`,
+		`CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events BEGIN SELECT RAISE(ABORT, 'audit events are append-only'); END`,
+	} {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// auditWriter records changes made in a transaction, attributing them to the
+// actor and request of the transaction's context.
+type auditWriter struct {
+	insert    *sql.Stmt
+	snapshot  *sql.Stmt
+	runners   *sql.Stmt
+	actor     string
+	requestID string
+}
+
+func newAuditWriter(ctx context.Context, tx *sql.Tx) (*auditWriter, error) {
+	queries := getRaceQueries()
+
+	insert, err := tx.PrepareContext(ctx, queries[auditInsert])
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := tx.PrepareContext(ctx, queries[racesSnapshot])
+	if err != nil {
+		insert.Close()
+		return nil, err
+	}
+
+	runners, err := tx.PrepareContext(ctx, queries[runnersList])
+	if err != nil {
+		snapshot.Close()
+		insert.Close()
+		return nil, err
+	}
+
+	return &auditWriter{
+		insert:    insert,
+		snapshot:  snapshot,
+		runners:   runners,
+		actor:     audit.Actor(ctx),
+		requestID: logging.RequestID(ctx),
+	}, nil
+}
+
+func (w *auditWriter) Close() error {
+	w.runners.Close()
+	w.snapshot.Close()
+	return w.insert.Close()
+}
+
+// race returns the race with the given ID and its field as currently stored,
+// or nil when there is none.
+func (w *auditWriter) race(ctx context.Context, id int64) (*racing.Race, error) {
+	var (
+		race            racing.Race
+		advertisedStart time.Time
+	)
+
+	err := w.snapshot.QueryRowContext(ctx, id).Scan(&race.Id, &race.MeetingId, &race.Name, &race.Number, &race.Visible, &advertisedStart, &race.Brand)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if race.AdvertisedStartTime, err = ptypes.TimestampProto(advertisedStart.UTC()); err != nil {
+		return nil, err
+	}
+
+	if race.Runners, err = scanRunners(w.runners.QueryContext(ctx, id)); err != nil {
+		return nil, err
+	}
+
+	return &race, nil
+}
+
+// record appends an event for the change of a race from before to after at
+// the given time, either of which is nil when the race did not exist.
+func (w *auditWriter) record(ctx context.Context, operation string, before, after *racing.Race, at time.Time) error {
+	subject := after
+	if subject == nil {
+		subject = before
+	}
+
+	beforeJSON, err := marshalSnapshot(before)
+	if err != nil {
+		return err
+	}
+
+	afterJSON, err := marshalSnapshot(after)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.insert.ExecContext(
+		ctx,
+		subject.Id,
+		operation,
+		w.actor,
+		w.requestID,
+		subject.Brand,
+		at.UTC().Format(auditTimeLayout),
+		beforeJSON,
+		afterJSON,
+	)
+
+	return err
+}
+
+// storedRace returns race as it is stored for brand, dropping derived fields
+// and the sub-second precision of its start time. Its runners are ordered by
+// number.
+func storedRace(race *racing.Race, brand string, advertisedStart time.Time) (*racing.Race, error) {
+	ts, err := ptypes.TimestampProto(advertisedStart.UTC().Truncate(time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	return &racing.Race{
+		Id:                  race.Id,
+		MeetingId:           race.MeetingId,
+		Name:                race.Name,
+		Number:              race.Number,
+		Visible:             race.Visible,
+		AdvertisedStartTime: ts,
+		Brand:               brand,
+		Runners:             sortedRunners(race.Runners),
+	}, nil
+}
+
+func marshalSnapshot(race *racing.Race) (sql.NullString, error) {
+	if race == nil {
+		return sql.NullString{}, nil
+	}
+
+	b, err := protojson.Marshal(race)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+func unmarshalSnapshot(s sql.NullString) (*racing.Race, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+
+	var race racing.Race
+	if err := protojson.Unmarshal([]byte(s.String), &race); err != nil {
+		return nil, err
+	}
+
+	return &race, nil
+}
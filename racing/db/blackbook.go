@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/golang/protobuf/ptypes"
+)
+
+// Types of entities customers may follow.
+const (
+	EntityRunner  = "runner"
+	EntityJockey  = "jockey"
+	EntityTrainer = "trainer"
+)
+
+// EntityTypes lists every type of entity customers may follow.
+var EntityTypes = []string{EntityRunner, EntityJockey, EntityTrainer}
+
+// entityIDPattern matches the IDs of runners, jockeys and trainers.
+var entityIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// ValidateEntityID returns an error unless id is a valid runner, jockey or
+// trainer ID.
+func ValidateEntityID(id string) error {
+	if !entityIDPattern.MatchString(id) {
+		return errors.New("must be 1 to 64 letters, digits, '.', ':', '_' or '-'")
+	}
+
+	return nil
+}
+
+// BlackbookRepo provides repository access to the entities each customer
+// follows.
+type BlackbookRepo interface {
+	// Init will create the blackbook table.
+	Init(ctx context.Context) error
+
+	// Follow will add an entity to the blackbook of the customer of the
+	// given brand and jurisdiction, returning the entry. Following an entity
+	// again keeps the original entry. Customers are only told of entries in
+	// the races they may see.
+	Follow(ctx context.Context, customer, brand, jurisdiction, entityType, entityID string) (*racing.BlackbookEntry, error)
+
+	// Unfollow will remove an entity from the customer's blackbook.
+	Unfollow(ctx context.Context, customer, entityType, entityID string) error
+
+	// List will return the customer's blackbook, optionally only the
+	// entities of entityType, most recently followed first.
+	List(ctx context.Context, customer, entityType string) ([]*racing.BlackbookEntry, error)
+}
+
+type blackbookRepo struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+}
+
+// NewBlackbookRepo creates a new blackbook repository. Each query is bounded
+// by queryTimeout, unless it is zero.
+func NewBlackbookRepo(db *sql.DB, queryTimeout time.Duration) BlackbookRepo {
+	return &blackbookRepo{db: db, queryTimeout: queryTimeout}
+}
+
+func (r *blackbookRepo) Init(ctx context.Context) error {
+	return createBlackbookSchema(ctx, r.db)
+}
+
+func (r *blackbookRepo) Follow(ctx context.Context, customer, brand, jurisdiction, entityType, entityID string) (entry *racing.BlackbookEntry, err error) {
+	ctx, done := startQuery(ctx, blackbookFollow, r.queryTimeout)
+	defer func() { done(err) }()
+
+	if _, err := r.db.ExecContext(ctx, getRaceQueries()[blackbookFollow], customer, entityType, entityID, time.Now().UTC().Format(auditTimeLayout), brand, jurisdiction); err != nil {
+		return nil, err
+	}
+
+	entries, err := r.scan(r.db.QueryContext(ctx, getRaceQueries()[blackbookEntry], customer, entityType, entityID))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return entries[0], nil
+}
+
+func (r *blackbookRepo) Unfollow(ctx context.Context, customer, entityType, entityID string) (err error) {
+	ctx, done := startQuery(ctx, blackbookUnfollow, r.queryTimeout)
+	defer func() { done(err) }()
+
+	_, err = r.db.ExecContext(ctx, getRaceQueries()[blackbookUnfollow], customer, entityType, entityID)
+
+	return err
+}
+
+func (r *blackbookRepo) List(ctx context.Context, customer, entityType string) (entries []*racing.BlackbookEntry, err error) {
+	ctx, done := startQuery(ctx, blackbookList, r.queryTimeout)
+	defer func() { done(err) }()
+
+	query := getRaceQueries()[blackbookList]
+	args := []interface{}{customer}
+
+	if entityType != "" {
+		query += " AND entity_type = ?"
+		args = append(args, entityType)
+	}
+
+	return r.scan(r.db.QueryContext(ctx, query+" ORDER BY created_at DESC, entity_type, entity_id", args...))
+}
+
+// scan reads the blackbook entries in rows.
+func (r *blackbookRepo) scan(rows *sql.Rows, err error) ([]*racing.BlackbookEntry, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*racing.BlackbookEntry
+
+	for rows.Next() {
+		var (
+			entry     racing.BlackbookEntry
+			createdAt string
+		)
+
+		if err := rows.Scan(&entry.EntityType, &entry.EntityId, &createdAt); err != nil {
+			return nil, err
+		}
+
+		t, err := time.Parse(auditTimeLayout, createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.CreatedAt, err = ptypes.TimestampProto(t); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// createBlackbookSchema creates the blackbook, which imports also read to
+// find the followers of the entities entered in races. Entries are indexed
+// by entity for them.
+func createBlackbookSchema(ctx context.Context, db *sql.DB) error {
+	for _, statement := range []string{
+		`CREATE TABLE IF NOT EXISTS blackbook (customer TEXT NOT NULL, entity_type TEXT NOT NULL, entity_id TEXT NOT NULL, created_at TEXT NOT NULL, PRIMARY KEY (customer, entity_type, entity_id))`,
+		`CREATE INDEX IF NOT EXISTS blackbook_entities ON blackbook (entity_type, entity_id)`,
+	} {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	for _, column := range []string{"brand", "jurisdiction"} {
+		if err := addColumn(ctx, db, "blackbook", column, `TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,286 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"git.neds.sh/matty/entain/racing/jurisdiction"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+)
+
+var (
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "racing",
+		Subsystem: "cache",
+		Name:      "lookups_total",
+		Help:      "Total number of races and rules cache lookups, by operation and result.",
+	}, []string{"operation", "result"})
+
+	cacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "racing",
+		Subsystem: "cache",
+		Name:      "evictions_total",
+		Help:      "Total number of races cache entries evicted to respect the size limit.",
+	})
+)
+
+// cachedRacesRepo is a read-through cache in front of a RacesRepo. Every
+// write through the repository invalidates the whole cache.
+type cachedRacesRepo struct {
+	repo       RacesRepo
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	races   []*racing.Race
+	expires time.Time
+}
+
+// NewCachedRacesRepo wraps repo with a read-through cache holding up to
+// maxEntries results, each for at most ttl. Cached races are shared between
+// callers, so they must not be modified.
+func NewCachedRacesRepo(repo RacesRepo, ttl time.Duration, maxEntries int) RacesRepo {
+	return &cachedRacesRepo{
+		repo:       repo,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Init initialises the underlying repository, invalidating the cache as it
+// may write to the races table.
+func (c *cachedRacesRepo) Init(ctx context.Context) error {
+	defer c.invalidate()
+
+	return c.repo.Init(ctx)
+}
+
+// List returns the cached races matching filter, querying the underlying
+// repository on a miss.
+func (c *cachedRacesRepo) List(ctx context.Context, brand string, filter *racing.ListRacesRequestFilter) ([]*racing.Race, error) {
+	key := cacheKey(brand, filter)
+
+	if races, ok := c.get(key); ok {
+		cacheLookups.WithLabelValues("list", "hit").Inc()
+		return races, nil
+	}
+
+	cacheLookups.WithLabelValues("list", "miss").Inc()
+
+	races, err := c.repo.List(ctx, brand, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	c.add(key, races)
+
+	return races, nil
+}
+
+// Get returns the cached race with the given ID, querying the underlying
+// repository on a miss. Missing races are not cached.
+func (c *cachedRacesRepo) Get(ctx context.Context, brand string, id int64) (*racing.Race, error) {
+	key := fmt.Sprintf("brand=%s&id=%d", brand, id)
+
+	if races, ok := c.get(key); ok {
+		cacheLookups.WithLabelValues("get", "hit").Inc()
+		return races[0], nil
+	}
+
+	cacheLookups.WithLabelValues("get", "miss").Inc()
+
+	race, err := c.repo.Get(ctx, brand, id)
+	if err != nil {
+		return nil, err
+	}
+
+	c.add(key, []*racing.Race{race})
+
+	return race, nil
+}
+
+// BeginImport starts an import in the underlying repository, which
+// invalidates the cache when it is committed.
+func (c *cachedRacesRepo) BeginImport(ctx context.Context, brand string) (RaceImport, error) {
+	imp, err := c.repo.BeginImport(ctx, brand)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cachedRaceImport{RaceImport: imp, cache: c}, nil
+}
+
+// cachedRaceImport invalidates a cache once its import is committed.
+type cachedRaceImport struct {
+	RaceImport
+	cache *cachedRacesRepo
+}
+
+func (i *cachedRaceImport) Commit() error {
+	defer i.cache.invalidate()
+
+	return i.RaceImport.Commit()
+}
+
+func (c *cachedRacesRepo) get(key string) ([]*racing.Race, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*cacheEntry)
+	if !c.now().Before(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+
+	// Hand out a copy of the slice so that callers may filter it freely.
+	return append([]*racing.Race(nil), e.races...), true
+}
+
+func (c *cachedRacesRepo) add(key string, races []*racing.Race) {
+	if c.ttl <= 0 || c.maxEntries <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := &cacheEntry{
+		key:     key,
+		races:   append([]*racing.Race(nil), races...),
+		expires: c.now().Add(c.ttl),
+	}
+
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(e)
+
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		cacheEvictions.Inc()
+	}
+}
+
+func (c *cachedRacesRepo) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// cacheKey normalises filter, so that equivalent filters share an entry. Each
+// brand has its own entries.
+func cacheKey(brand string, filter *racing.ListRacesRequestFilter) string {
+	meetingIDs := append([]int64(nil), filter.GetMeetingIds()...)
+	sort.Slice(meetingIDs, func(i, j int) bool { return meetingIDs[i] < meetingIDs[j] })
+
+	var ids []string
+	for i, id := range meetingIDs {
+		if i > 0 && id == meetingIDs[i-1] {
+			continue
+		}
+
+		ids = append(ids, fmt.Sprint(id))
+	}
+
+	visible := "any"
+	if filter != nil && filter.Visible != nil {
+		visible = fmt.Sprint(filter.GetVisible())
+	}
+
+	// Fall back to the raw expression for invalid orderings, which are
+	// rejected by the underlying repository and never cached.
+	orderBy, err := ParseRaceOrderBy(filter.GetOrderBy())
+	if err != nil {
+		orderBy = filter.GetOrderBy()
+	}
+
+	return strings.Join([]string{
+		"brand=" + brand,
+		"meeting_ids=" + strings.Join(ids, ","),
+		"visible=" + visible,
+		"order_by=" + strings.TrimSpace(orderBy),
+		"date=" + filter.GetDate(),
+		"timezone=" + filter.GetTimezone(),
+	}, "&")
+}
+
+// cachedRulesRepo holds the jurisdiction rules for up to ttl, as they are
+// needed by every read of races but rarely change.
+type cachedRulesRepo struct {
+	repo RulesRepo
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	rules   []jurisdiction.Rule
+	expires time.Time
+}
+
+// NewCachedRulesRepo wraps repo with a cache of its rules, refreshed at most
+// once every ttl. Rules changed directly in the database are therefore seen
+// within ttl.
+func NewCachedRulesRepo(repo RulesRepo, ttl time.Duration) RulesRepo {
+	if ttl <= 0 {
+		return repo
+	}
+
+	return &cachedRulesRepo{repo: repo, ttl: ttl, now: time.Now}
+}
+
+// List returns the cached rules, querying the underlying repository once
+// they have expired. Concurrent callers wait for a single query.
+func (c *cachedRulesRepo) List(ctx context.Context) ([]jurisdiction.Rule, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.now().Before(c.expires) {
+		cacheLookups.WithLabelValues("rules", "hit").Inc()
+		return c.rules, nil
+	}
+
+	cacheLookups.WithLabelValues("rules", "miss").Inc()
+
+	rules, err := c.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.rules, c.expires = rules, c.now().Add(c.ttl)
+
+	return rules, nil
+}
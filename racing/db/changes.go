@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/proto/racing"
+)
+
+// changeRecorder records each change made to races in a transaction, both in
+// the audit log and as an event in the outbox.
+type changeRecorder struct {
+	audit  *auditWriter
+	outbox *outboxWriter
+}
+
+func newChangeRecorder(ctx context.Context, tx *sql.Tx) (*changeRecorder, error) {
+	auditor, err := newAuditWriter(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	outbox, err := newOutboxWriter(ctx, tx)
+	if err != nil {
+		auditor.Close()
+		return nil, err
+	}
+
+	return &changeRecorder{audit: auditor, outbox: outbox}, nil
+}
+
+func (r *changeRecorder) Close() error {
+	r.outbox.Close()
+	return r.audit.Close()
+}
+
+// race returns the race with the given ID as currently stored, or nil when
+// there is none.
+func (r *changeRecorder) race(ctx context.Context, id int64) (*racing.Race, error) {
+	return r.audit.race(ctx, id)
+}
+
+// record records the change of a race from before to after, either of which
+// is nil when the race did not exist.
+func (r *changeRecorder) record(ctx context.Context, operation string, before, after *racing.Race) error {
+	now := time.Now()
+
+	if err := r.audit.record(ctx, operation, before, after, now); err != nil {
+		return err
+	}
+
+	return r.outbox.enqueue(ctx, operation, before, after, now)
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// dateLayout is the layout of local race dates.
+	dateLayout = "2006-01-02"
+
+	// sqlTimeLayout is the layout of SQLite's datetime function, which
+	// normalises the RFC 3339 start times stored with assorted offsets to
+	// UTC so that they can be compared and ordered.
+	sqlTimeLayout = "2006-01-02 15:04:05"
+
+	// Today selects the current date in a date filter.
+	Today = "today"
+
+	// defaultTimezone is used for races whose meeting has no venue timezone.
+	defaultTimezone = "UTC"
+)
+
+var locations sync.Map
+
+// LoadTimezone returns the location for an IANA timezone name. Unlike
+// time.LoadLocation, it rejects the empty and "Local" names, whose meaning
+// depends on the host.
+func LoadTimezone(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+
+	locations.Store(name, loc)
+
+	return loc, nil
+}
+
+// ParseRaceDate checks that date is a YYYY-MM-DD date or "today".
+func ParseRaceDate(date string) error {
+	if date == Today {
+		return nil
+	}
+
+	if _, err := time.Parse(dateLayout, date); err != nil {
+		return errors.New("must be a YYYY-MM-DD date or \"today\"")
+	}
+
+	return nil
+}
+
+// dateFilter selects races starting on a local date, either in a fixed
+// timezone or, when loc is nil, in the timezone of each race's venue.
+type dateFilter struct {
+	date string
+	loc  *time.Location
+}
+
+func newDateFilter(date, timezone string) (*dateFilter, error) {
+	if err := ParseRaceDate(date); err != nil {
+		return nil, fmt.Errorf("date: %w", err)
+	}
+
+	f := &dateFilter{date: date}
+
+	if timezone != "" {
+		loc, err := LoadTimezone(timezone)
+		if err != nil {
+			return nil, err
+		}
+
+		f.loc = loc
+	}
+
+	return f, nil
+}
+
+// localDate returns the date selected by the filter in loc.
+func (f *dateFilter) localDate(now time.Time, loc *time.Location) string {
+	if f.date == Today {
+		return now.In(loc).Format(dateLayout)
+	}
+
+	return f.date
+}
+
+// window returns the UTC times between which selected races start. It is
+// exact for a fixed timezone, and otherwise wide enough to cover the date in
+// every timezone, leaving matches to pick the races on the date at their
+// venue.
+func (f *dateFilter) window(now time.Time) (time.Time, time.Time) {
+	if f.loc != nil {
+		day, _ := time.ParseInLocation(dateLayout, f.localDate(now, f.loc), f.loc)
+
+		// Midnight is found with time.Date rather than by adding 24 hours,
+		// so that days lengthened or shortened by DST are respected.
+		return day, time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, f.loc)
+	}
+
+	if f.date == Today {
+		// A local day, which DST lengthens to 25 hours at most, starts less
+		// than 25 hours ago and ends within 25 hours from now. The window
+		// allows an hour more either side.
+		return now.Add(-26 * time.Hour), now.Add(26 * time.Hour)
+	}
+
+	// Timezones range from UTC-12 to UTC+14.
+	day, _ := time.Parse(dateLayout, f.date)
+
+	return day.Add(-14 * time.Hour), day.Add(36 * time.Hour)
+}
+
+// matches reports whether a race starting at start at a venue in loc falls
+// on the selected date.
+func (f *dateFilter) matches(now, start time.Time, loc *time.Location) bool {
+	if f.loc != nil {
+		loc = f.loc
+	}
+
+	return start.In(loc).Format(dateLayout) == f.localDate(now, loc)
+}
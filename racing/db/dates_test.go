@@ -0,0 +1,126 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// sydney observes DST, starting on the first Sunday of October and ending on
+// the first Sunday of April.
+var sydney, _ = LoadTimezone("Australia/Sydney")
+
+func utc(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+func TestDateFilterWindow(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		date     string
+		timezone string
+		now      time.Time
+		from, to time.Time
+	}{
+		{
+			name:     "standard day",
+			date:     "2024-06-01",
+			timezone: "Australia/Sydney",
+			from:     utc("2024-05-31T14:00:00Z"),
+			to:       utc("2024-06-01T14:00:00Z"),
+		},
+		{
+			name:     "spring forward lasts 23 hours",
+			date:     "2024-10-06",
+			timezone: "Australia/Sydney",
+			from:     utc("2024-10-05T14:00:00Z"),
+			to:       utc("2024-10-06T13:00:00Z"),
+		},
+		{
+			name:     "fall back lasts 25 hours",
+			date:     "2024-04-07",
+			timezone: "Australia/Sydney",
+			from:     utc("2024-04-06T13:00:00Z"),
+			to:       utc("2024-04-07T14:00:00Z"),
+		},
+		{
+			name:     "today in a timezone",
+			date:     Today,
+			timezone: "Australia/Sydney",
+			now:      utc("2024-10-06T05:00:00Z"),
+			from:     utc("2024-10-05T14:00:00Z"),
+			to:       utc("2024-10-06T13:00:00Z"),
+		},
+		{
+			name: "today at each venue",
+			date: Today,
+			now:  utc("2024-10-06T05:00:00Z"),
+			from: utc("2024-10-05T03:00:00Z"),
+			to:   utc("2024-10-07T07:00:00Z"),
+		},
+		{
+			name: "date at each venue",
+			date: "2024-10-06",
+			from: utc("2024-10-05T10:00:00Z"),
+			to:   utc("2024-10-07T12:00:00Z"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := newDateFilter(tc.date, tc.timezone)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			from, to := f.window(tc.now)
+			if !from.Equal(tc.from) || !to.Equal(tc.to) {
+				t.Errorf("got window %s to %s, want %s to %s", from.UTC(), to.UTC(), tc.from, tc.to)
+			}
+		})
+	}
+}
+
+func TestDateFilterMatches(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		date     string
+		timezone string
+		now      time.Time
+		start    time.Time
+		want     bool
+	}{
+		{name: "spring forward starts at midnight AEST", date: "2024-10-06", start: utc("2024-10-05T14:00:00Z"), want: true},
+		{name: "before spring forward", date: "2024-10-06", start: utc("2024-10-05T13:59:00Z")},
+		{name: "spring forward ends at midnight AEDT", date: "2024-10-06", start: utc("2024-10-06T12:59:00Z"), want: true},
+		{name: "after spring forward", date: "2024-10-06", start: utc("2024-10-06T13:00:00Z")},
+		{name: "fall back starts at midnight AEDT", date: "2024-04-07", start: utc("2024-04-06T13:00:00Z"), want: true},
+		{name: "first 2:30 of fall back", date: "2024-04-07", start: utc("2024-04-06T15:30:00Z"), want: true},
+		{name: "second 2:30 of fall back", date: "2024-04-07", start: utc("2024-04-06T16:30:00Z"), want: true},
+		{name: "fall back ends at midnight AEST", date: "2024-04-07", start: utc("2024-04-07T13:59:00Z"), want: true},
+		{name: "after fall back", date: "2024-04-07", start: utc("2024-04-07T14:00:00Z")},
+		{name: "today at the venue", date: Today, now: utc("2024-10-06T12:30:00Z"), start: utc("2024-10-05T14:30:00Z"), want: true},
+		{name: "tomorrow at the venue", date: Today, now: utc("2024-10-06T12:30:00Z"), start: utc("2024-10-06T13:30:00Z")},
+		{name: "timezone overrides the venue's", date: "2024-10-06", timezone: "UTC", start: utc("2024-10-06T13:30:00Z"), want: true},
+		{name: "venue date outside the timezone's", date: "2024-10-06", timezone: "UTC", start: utc("2024-10-05T14:30:00Z")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := newDateFilter(tc.date, tc.timezone)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := f.matches(tc.now, tc.start, sydney); got != tc.want {
+				t.Errorf("got match %t for a race at %s, want %t", got, tc.start.In(sydney), tc.want)
+			}
+
+			// Races on the date at their venue are always within the window
+			// the database is queried for.
+			if from, to := f.window(tc.now); tc.want && (tc.start.Before(from) || !tc.start.Before(to)) {
+				t.Errorf("race at %s is outside the window %s to %s", tc.start, from, to)
+			}
+		})
+	}
+}
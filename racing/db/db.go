@@ -1,30 +1,289 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"time"
 
-	"syreclabs.com/go/faker"
+	"git.neds.sh/matty/entain/racing/audit"
+	"go.uber.org/zap"
 )
 
-func (r *racesRepo) seed() error {
-	statement, err := r.db.Prepare(`CREATE TABLE IF NOT EXISTS races (id INTEGER PRIMARY KEY, meeting_id INTEGER, name TEXT, number INTEGER, visible INTEGER, advertised_start_time DATETIME)`)
-	if err == nil {
-		_, err = statement.Exec()
+func (r *racesRepo) seed(ctx context.Context) error {
+	if _, err := r.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS races (id INTEGER PRIMARY KEY, meeting_id INTEGER, name TEXT, number INTEGER, visible INTEGER, advertised_start_time DATETIME, brand TEXT NOT NULL DEFAULT '', updated_at DATETIME)`); err != nil {
+		return err
 	}
 
-	for i := 1; i <= 100; i++ {
-		statement, err = r.db.Prepare(`INSERT OR IGNORE INTO races(id, meeting_id, name, number, visible, advertised_start_time) VALUES (?,?,?,?,?,?)`)
-		if err == nil {
-			_, err = statement.Exec(
-				i,
-				faker.Number().Between(1, 10),
-				faker.Team().Name(),
-				faker.Number().Between(1, 12),
-				faker.Number().Between(0, 1),
-				faker.Time().Between(time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 2)).Format(time.RFC3339),
-			)
+	if _, err := r.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS meetings (id INTEGER PRIMARY KEY, name TEXT, venue_timezone TEXT NOT NULL, brand TEXT NOT NULL DEFAULT '')`); err != nil {
+		return err
+	}
+
+	// Databases created before races and meetings were branded gain the
+	// column, leaving their existing content shared by every brand.
+	for _, table := range []string{"races", "meetings"} {
+		if err := addColumn(ctx, r.db, table, "brand", `TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
 		}
 	}
 
+	// Races stored before changes were timestamped are taken to have last
+	// changed when the column was added.
+	if err := addColumn(ctx, r.db, "races", "updated_at", `DATETIME`); err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, `UPDATE races SET updated_at = ? WHERE updated_at IS NULL`, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS race_visibility_overrides (brand TEXT NOT NULL, race_id INTEGER NOT NULL, visible INTEGER NOT NULL, PRIMARY KEY (brand, race_id))`); err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS jurisdiction_rules (id INTEGER PRIMARY KEY, meeting_id INTEGER NOT NULL DEFAULT 0, race_id INTEGER NOT NULL DEFAULT 0, region TEXT NOT NULL, effect TEXT NOT NULL, UNIQUE (meeting_id, race_id, region))`); err != nil {
+		return err
+	}
+
+	if err := createRunnersSchema(ctx, r.db); err != nil {
+		return err
+	}
+
+	// Imports notify the followers of the entities they enter in races, so
+	// the blackbook is needed before the blackbook repository is ready.
+	if err := createBlackbookSchema(ctx, r.db); err != nil {
+		return err
+	}
+
+	if err := createAuditSchema(ctx, r.db); err != nil {
+		return err
+	}
+
+	if err := createOutboxSchema(ctx, r.db); err != nil {
+		return err
+	}
+
+	// Outboxes created before customer notifications were added gain their
+	// columns.
+	if err := addColumn(ctx, r.db, "outbox_events", "customer", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+
+	if err := addColumn(ctx, r.db, "outbox_events", "data", `TEXT`); err != nil {
+		return err
+	}
+
+	cfg := r.seedConfig
+	if len(cfg.Fixtures) == 0 && cfg.Meetings*cfg.RacesPerMeeting == 0 && !cfg.Reset {
+		return nil
+	}
+
+	start := cfg.Start
+	if start.IsZero() {
+		start = time.Now()
+	}
+
+	var (
+		data *seedData
+		err  error
+	)
+
+	if len(cfg.Fixtures) > 0 {
+		data, err = loadFixtures(cfg.Fixtures, start)
+		if err != nil {
+			return err
+		}
+
+		zap.L().Info("seeding races from fixtures",
+			zap.Strings("fixtures", cfg.Fixtures),
+			zap.Int("meetings", len(data.meetings)),
+			zap.Int("races", len(data.races)),
+			zap.Int("jurisdiction_rules", len(data.rules)),
+			zap.Int("visibility_overrides", len(data.overrides)),
+		)
+	} else {
+		seed := cfg.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+
+		data = &seedData{}
+		data.meetings, data.races = generateRaces(cfg, seed, start)
+
+		zap.L().Info("seeding generated races", zap.Int64("seed", seed), zap.Int("meetings", len(data.meetings)), zap.Int("races", len(data.races)))
+	}
+
+	// Seeded changes are recorded like any other, on behalf of the seeder.
+	ctx = audit.WithActor(ctx, audit.SystemSeed)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	changes, err := newChangeRecorder(ctx, tx)
+	if err != nil {
+		return err
+	}
+	defer changes.Close()
+
+	if cfg.Reset {
+		if err := recordReset(ctx, tx, changes); err != nil {
+			return err
+		}
+
+		for _, table := range []string{"races", "runners", "meetings", "jurisdiction_rules", "race_visibility_overrides"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table); err != nil {
+				return err
+			}
+		}
+	}
+
+	meetingStatement, err := tx.PrepareContext(ctx, `INSERT OR IGNORE INTO meetings(id, name, venue_timezone, brand) VALUES (?,?,?,?)`)
+	if err != nil {
+		return err
+	}
+	defer meetingStatement.Close()
+
+	for _, meeting := range data.meetings {
+		if _, err := meetingStatement.ExecContext(ctx, meeting.id, meeting.name, meeting.venueTimezone, meeting.brand); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	statement, err := tx.PrepareContext(ctx, `INSERT OR IGNORE INTO races(id, meeting_id, name, number, visible, advertised_start_time, brand, updated_at) VALUES (?,?,?,?,?,?,?,?)`)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	for _, race := range data.races {
+		res, err := statement.ExecContext(
+			ctx,
+			race.id,
+			race.meetingID,
+			race.name,
+			race.number,
+			race.visible,
+			race.advertisedStartTime.UTC().Format(time.RFC3339),
+			race.brand,
+			now,
+		)
+		if err != nil {
+			return err
+		}
+
+		// Races that already exist are left untouched.
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+
+		after, err := changes.race(ctx, race.id)
+		if err != nil {
+			return err
+		}
+
+		if err := changes.record(ctx, OperationCreate, nil, after); err != nil {
+			return err
+		}
+	}
+
+	ruleStatement, err := tx.PrepareContext(ctx, `INSERT OR IGNORE INTO jurisdiction_rules(meeting_id, race_id, region, effect) VALUES (?,?,?,?)`)
+	if err != nil {
+		return err
+	}
+	defer ruleStatement.Close()
+
+	for _, rule := range data.rules {
+		if _, err := ruleStatement.ExecContext(ctx, rule.MeetingID, rule.RaceID, rule.Region, string(rule.Effect)); err != nil {
+			return err
+		}
+	}
+
+	overrideStatement, err := tx.PrepareContext(ctx, `INSERT OR IGNORE INTO race_visibility_overrides(brand, race_id, visible) VALUES (?,?,?)`)
+	if err != nil {
+		return err
+	}
+	defer overrideStatement.Close()
+
+	for _, override := range data.overrides {
+		if _, err := overrideStatement.ExecContext(ctx, override.brand, override.raceID, override.visible); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// addColumn adds a column to table unless it already has it.
+func addColumn(ctx context.Context, db *sql.DB, table, column, definition string) error {
+	rows, err := db.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+
+		if name == column {
+			return nil
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `ALTER TABLE `+table+` ADD COLUMN `+column+` `+definition)
+
 	return err
 }
+
+// recordReset records the deletion of every race by a reset.
+func recordReset(ctx context.Context, tx *sql.Tx, changes *changeRecorder) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM races ORDER BY id`)
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+
+		ids = append(ids, id)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		before, err := changes.race(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := changes.record(ctx, OperationDelete, before, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("git.neds.sh/matty/entain/racing/db")
+
+var (
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "racing",
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of SQL queries issued by the racing repositories.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"query"})
+
+	queryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "racing",
+		Subsystem: "db",
+		Name:      "query_errors_total",
+		Help:      "Total number of SQL queries issued by the racing repositories that failed.",
+	}, []string{"query"})
+)
+
+// startQuery prepares ctx for running the named query, bounding it by timeout
+// when positive. The returned function must be called with the outcome of the
+// query once it has completed, to record its span and metrics.
+func startQuery(ctx context.Context, query string, timeout time.Duration) (context.Context, func(error)) {
+	start := time.Now()
+
+	ctx, span := tracer.Start(ctx, "db."+query, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "sqlite"),
+		attribute.String("db.operation", query),
+	))
+
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	return ctx, func(err error) {
+		cancel()
+
+		queryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+
+		if err != nil {
+			queryErrors.WithLabelValues(query).Inc()
+
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		span.End()
+	}
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// raceOrderColumns maps the fields races may be ordered by onto their columns.
+var raceOrderColumns = map[string]string{
+	"id":         "r.id",
+	"meeting_id": "r.meeting_id",
+	"name":       "r.name",
+	"number":     "r.number",
+	"visible":    "COALESCE(o.visible, r.visible)",
+	// Start times are stored with assorted offsets, so they are normalised
+	// to UTC to order them chronologically.
+	"advertised_start_time": "datetime(r.advertised_start_time)",
+}
+
+// ParseRaceOrderBy converts an order_by expression, made of comma separated
+// field names each optionally followed by "asc" or "desc", into an SQL ORDER
+// BY clause. Only whitelisted fields are accepted, so the result is safe to
+// concatenate into a query.
+func ParseRaceOrderBy(orderBy string) (string, error) {
+	if strings.TrimSpace(orderBy) == "" {
+		return "", nil
+	}
+
+	var terms []string
+
+	for _, term := range strings.Split(orderBy, ",") {
+		parts := strings.Fields(term)
+		if len(parts) == 0 || len(parts) > 2 {
+			return "", fmt.Errorf("invalid order term %q", strings.TrimSpace(term))
+		}
+
+		column, ok := raceOrderColumns[parts[0]]
+		if !ok {
+			return "", fmt.Errorf("cannot order by unknown field %q", parts[0])
+		}
+
+		direction := "ASC"
+		if len(parts) == 2 {
+			switch strings.ToLower(parts[1]) {
+			case "asc":
+			case "desc":
+				direction = "DESC"
+			default:
+				return "", fmt.Errorf("invalid order direction %q", parts[1])
+			}
+		}
+
+		terms = append(terms, column+" "+direction)
+	}
+
+	return " ORDER BY " + strings.Join(terms, ", "), nil
+}
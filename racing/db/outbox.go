@@ -0,0 +1,191 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"git.neds.sh/matty/entain/common/logging"
+	"git.neds.sh/matty/entain/racing/events"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// eventTypes maps audited operations onto the events they raise.
+var eventTypes = map[string]string{
+	OperationCreate: events.RaceCreated,
+	OperationUpdate: events.RaceUpdated,
+	OperationDelete: events.RaceDeleted,
+}
+
+// OutboxRepo provides access to the outbox of race events, which the races
+// repository fills in the same transaction as the changes they describe.
+type OutboxRepo interface {
+	// Pending will return up to limit unpublished events, oldest first.
+	Pending(ctx context.Context, limit int) ([]events.Event, error)
+
+	// MarkPublished will record that the events with the given IDs have
+	// been published.
+	MarkPublished(ctx context.Context, ids []int64) error
+}
+
+type outboxRepo struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+}
+
+// NewOutboxRepo creates a new outbox repository. Each query is bounded by
+// queryTimeout, unless it is zero.
+func NewOutboxRepo(db *sql.DB, queryTimeout time.Duration) OutboxRepo {
+	return &outboxRepo{db: db, queryTimeout: queryTimeout}
+}
+
+func (r *outboxRepo) Pending(ctx context.Context, limit int) (pending []events.Event, err error) {
+	ctx, done := startQuery(ctx, outboxPending, r.queryTimeout)
+	defer func() { done(err) }()
+
+	rows, err := r.db.QueryContext(ctx, getRaceQueries()[outboxPending], limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			event      events.Event
+			occurredAt string
+			race       string
+			previous   sql.NullString
+			data       sql.NullString
+		)
+
+		if err := rows.Scan(&event.ID, &event.Type, &event.RaceID, &event.Brand, &occurredAt, &event.RequestID, &race, &previous, &event.Customer, &data); err != nil {
+			return nil, err
+		}
+
+		if event.OccurredAt, err = time.Parse(auditTimeLayout, occurredAt); err != nil {
+			return nil, err
+		}
+
+		event.Race = json.RawMessage(race)
+		if previous.Valid {
+			event.Previous = json.RawMessage(previous.String)
+		}
+		if data.Valid {
+			event.Data = json.RawMessage(data.String)
+		}
+
+		pending = append(pending, event)
+	}
+
+	return pending, rows.Err()
+}
+
+func (r *outboxRepo) MarkPublished(ctx context.Context, ids []int64) (err error) {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	ctx, done := startQuery(ctx, outboxPublished, r.queryTimeout)
+	defer func() { done(err) }()
+
+	args := []interface{}{time.Now().UTC().Format(auditTimeLayout)}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	query := getRaceQueries()[outboxPublished] + " WHERE id IN (" + strings.Repeat("?,", len(ids)-1) + "?)"
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+
+	return err
+}
+
+// createOutboxSchema creates the outbox. Unpublished events are indexed, so
+// that the relay finds them quickly however many have been published.
+func createOutboxSchema(ctx context.Context, db *sql.DB) error {
+	for _, statement := range []string{
+		`CREATE TABLE IF NOT EXISTS outbox_events (id INTEGER PRIMARY KEY AUTOINCREMENT, type TEXT NOT NULL, race_id INTEGER NOT NULL, brand TEXT NOT NULL, occurred_at TEXT NOT NULL, request_id TEXT NOT NULL, race TEXT NOT NULL, previous TEXT, published_at TEXT, customer TEXT NOT NULL DEFAULT '', data TEXT)`,
+		`CREATE INDEX IF NOT EXISTS outbox_events_pending ON outbox_events (id) WHERE published_at IS NULL`,
+	} {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// outboxWriter queues events for the changes made in a transaction.
+type outboxWriter struct {
+	insert    *sql.Stmt
+	requestID string
+}
+
+func newOutboxWriter(ctx context.Context, tx *sql.Tx) (*outboxWriter, error) {
+	insert, err := tx.PrepareContext(ctx, getRaceQueries()[outboxInsert])
+	if err != nil {
+		return nil, err
+	}
+
+	return &outboxWriter{insert: insert, requestID: logging.RequestID(ctx)}, nil
+}
+
+func (w *outboxWriter) Close() error {
+	return w.insert.Close()
+}
+
+// enqueue queues the event raised by changing a race from before to after,
+// either of which is nil when the race did not exist.
+func (w *outboxWriter) enqueue(ctx context.Context, operation string, before, after *racing.Race, at time.Time) error {
+	race, previous := after, before
+	if race == nil {
+		race, previous = before, nil
+	}
+
+	event := events.Event{
+		Type:       eventTypes[operation],
+		RaceID:     race.Id,
+		Brand:      race.Brand,
+		OccurredAt: at,
+	}
+
+	var err error
+
+	if event.Race, err = protojson.Marshal(race); err != nil {
+		return err
+	}
+
+	if previous != nil {
+		if event.Previous, err = protojson.Marshal(previous); err != nil {
+			return err
+		}
+	}
+
+	return w.add(ctx, event)
+}
+
+// add queues event, which is given its ID once it has been stored.
+func (w *outboxWriter) add(ctx context.Context, event events.Event) error {
+	_, err := w.insert.ExecContext(
+		ctx,
+		event.Type,
+		event.RaceID,
+		event.Brand,
+		event.OccurredAt.UTC().Format(auditTimeLayout),
+		w.requestID,
+		string(event.Race),
+		nullJSON(event.Previous),
+		event.Customer,
+		nullJSON(event.Data),
+	)
+
+	return err
+}
+
+// nullJSON stores empty JSON as NULL.
+func nullJSON(raw json.RawMessage) sql.NullString {
+	return sql.NullString{String: string(raw), Valid: len(raw) > 0}
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/events"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// writeRace imports race for the neds brand, rolling the import back unless
+// commit is set.
+func writeRace(ctx context.Context, repo RacesRepo, race *racing.Race, commit bool) error {
+	imp, err := repo.BeginImport(ctx, "neds")
+	if err != nil {
+		return err
+	}
+
+	if _, err := imp.Write(ctx, []*racing.Race{race}); err != nil {
+		imp.Rollback()
+		return err
+	}
+
+	if !commit {
+		return imp.Rollback()
+	}
+
+	return imp.Commit()
+}
+
+// stored reports whether the race with the given ID was stored, and whether
+// its race.created event was queued.
+func stored(t *testing.T, races RacesRepo, outbox OutboxRepo, id int64) (race, event bool) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	switch _, err := races.Get(ctx, "neds", id); {
+	case err == nil:
+		race = true
+	case !errors.Is(err, sql.ErrNoRows):
+		t.Fatal(err)
+	}
+
+	pending, err := outbox.Pending(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range pending {
+		event = event || (e.Type == events.RaceCreated && e.RaceID == id)
+	}
+
+	return race, event
+}
+
+func TestOutboxWrittenWithRaceChange(t *testing.T) {
+	ctx := context.Background()
+
+	racingDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "racing.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer racingDB.Close()
+
+	races := NewRacesRepo(racingDB, 0, SeedConfig{})
+	if err := races.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := NewOutboxRepo(racingDB, 0)
+	race := &racing.Race{Id: 1, MeetingId: 1, Name: "Cox Plate", Number: 9, AdvertisedStartTime: timestamppb.New(time.Now().Add(time.Hour))}
+
+	// Neither the race nor its event survive a rollback.
+	if err := writeRace(ctx, races, race, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if gotRace, gotEvent := stored(t, races, outbox, race.Id); gotRace || gotEvent {
+		t.Fatalf("got race stored %v and event queued %v after a rollback, want neither", gotRace, gotEvent)
+	}
+
+	// Failing to queue the event undoes the race change.
+	if _, err := racingDB.ExecContext(ctx, `CREATE TRIGGER outbox_unavailable BEFORE INSERT ON outbox_events BEGIN SELECT RAISE(ABORT, 'outbox unavailable'); END`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeRace(ctx, races, race, true); err == nil {
+		t.Fatal("got no error importing while the outbox is unavailable")
+	}
+
+	if gotRace, gotEvent := stored(t, races, outbox, race.Id); gotRace || gotEvent {
+		t.Fatalf("got race stored %v and event queued %v after a failed import, want neither", gotRace, gotEvent)
+	}
+
+	if _, err := racingDB.ExecContext(ctx, `DROP TRIGGER outbox_unavailable`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both are stored once the import commits.
+	if err := writeRace(ctx, races, race, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if gotRace, gotEvent := stored(t, races, outbox, race.Id); !gotRace || !gotEvent {
+		t.Fatalf("got race stored %v and event queued %v after a commit, want both", gotRace, gotEvent)
+	}
+}
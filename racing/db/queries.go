@@ -1,20 +1,208 @@
 package db
 
 const (
-	racesList = "list"
+	racesList     = "list"
+	racesGet      = "get"
+	racesSeed     = "seed"
+	racesImport   = "import"
+	racesSnapshot = "snapshot"
+
+	runnersList    = "runners_list"
+	runnersReplace = "runners_replace"
+	runnersInsert  = "runners_insert"
+
+	rulesList = "rules_list"
+
+	auditList   = "audit_list"
+	auditInsert = "audit_insert"
+
+	outboxPending   = "outbox_pending"
+	outboxPublished = "outbox_published"
+	outboxInsert    = "outbox_insert"
+
+	webhooksCreate     = "webhooks_create"
+	webhooksGet        = "webhooks_get"
+	webhooksMatching   = "webhooks_matching"
+	webhooksEnqueue    = "webhooks_enqueue"
+	webhooksDue        = "webhooks_due"
+	webhooksAttempt    = "webhooks_attempt"
+	webhooksReschedule = "webhooks_reschedule"
+	webhooksDeliveries = "webhooks_deliveries"
+	webhooksAttempts   = "webhooks_attempts"
+
+	remindersSubscribe    = "reminders_subscribe"
+	remindersSubscription = "reminders_subscription"
+	remindersUnsubscribe  = "reminders_unsubscribe"
+	remindersDue          = "reminders_due"
+	remindersSent         = "reminders_sent"
+
+	blackbookFollow    = "blackbook_follow"
+	blackbookEntry     = "blackbook_entry"
+	blackbookUnfollow  = "blackbook_unfollow"
+	blackbookList      = "blackbook_list"
+	blackbookFollowers = "blackbook_followers"
 )
 
 func getRaceQueries() map[string]string {
 	return map[string]string{
+		// Every list is scoped to a brand, whose ID is bound to the three
+		// leading parameters. Races and meetings without a brand are shared
+		// by all brands, and the brand may override whether races are visible.
 		racesList: `
 			SELECT 
-				id, 
-				meeting_id, 
-				name, 
-				number, 
-				visible, 
-				advertised_start_time 
-			FROM races
+				r.id, 
+				r.meeting_id, 
+				r.name, 
+				r.number, 
+				COALESCE(o.visible, r.visible), 
+				r.advertised_start_time,
+				COALESCE(m.venue_timezone, '` + defaultTimezone + `'),
+				r.brand,
+				r.updated_at
+			FROM races r
+			LEFT JOIN meetings m ON m.id = r.meeting_id
+			LEFT JOIN race_visibility_overrides o ON o.race_id = r.id AND o.brand = ?
+			WHERE r.brand IN ('', ?) AND COALESCE(m.brand, '') IN ('', ?)
+		`,
+		racesSnapshot:  `SELECT id, meeting_id, name, number, visible, advertised_start_time, brand FROM races WHERE id = ?`,
+		rulesList:      `SELECT meeting_id, race_id, region, effect FROM jurisdiction_rules`,
+		runnersList:    `SELECT number, runner_id, jockey_id, trainer_id FROM runners WHERE race_id = ? ORDER BY number`,
+		runnersReplace: `DELETE FROM runners WHERE race_id = ?`,
+		runnersInsert: `
+			INSERT INTO runners (race_id, number, runner_id, jockey_id, trainer_id)
+			VALUES (?, ?, ?, ?, ?)
+		`,
+		racesImport: `
+			INSERT INTO races (id, meeting_id, name, number, visible, advertised_start_time, brand, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET
+				meeting_id = excluded.meeting_id,
+				name = excluded.name,
+				number = excluded.number,
+				visible = excluded.visible,
+				advertised_start_time = excluded.advertised_start_time,
+				updated_at = excluded.updated_at
+			WHERE races.brand = excluded.brand
+		`,
+		auditList: `SELECT id, race_id, operation, actor, request_id, occurred_at, before, after FROM audit_events`,
+		auditInsert: `
+			INSERT INTO audit_events (race_id, operation, actor, request_id, brand, occurred_at, before, after)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+		outboxPending: `
+			SELECT id, type, race_id, brand, occurred_at, request_id, race, previous, customer, data
+			FROM outbox_events
+			WHERE published_at IS NULL
+			ORDER BY id
+			LIMIT ?
+		`,
+		outboxPublished: `UPDATE outbox_events SET published_at = ?`,
+		outboxInsert: `
+			INSERT INTO outbox_events (type, race_id, brand, occurred_at, request_id, race, previous, customer, data)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+		webhooksCreate: `
+			INSERT INTO webhooks (url, event_types, secret, owner, brand, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`,
+		webhooksGet: `SELECT id, url, event_types, secret, owner, brand, created_at FROM webhooks WHERE id = ?`,
+		// Events of shared races are delivered to every webhook, while those
+		// of a brand's races are only delivered to the brand's webhooks, so
+		// webhooks without a brand only receive events of shared races.
+		// Webhooks without event types receive events of every type, and
+		// notifications for a customer only go to the customer's webhooks.
+		webhooksMatching: `
+			SELECT id
+			FROM webhooks
+			WHERE ? IN ('', brand)
+				AND ? IN ('', owner)
+				AND (event_types = '' OR ',' || event_types || ',' LIKE '%,' || ? || ',%')
+			ORDER BY id
+		`,
+		webhooksEnqueue: `
+			INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, status, created_at, next_attempt_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (webhook_id, event_id) DO NOTHING
+		`,
+		webhooksDue: `
+			SELECT d.id, d.webhook_id, w.url, w.secret, d.event_id, d.event_type, d.payload, d.attempts
+			FROM webhook_deliveries d
+			JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.status = 'pending' AND d.next_attempt_at <= ?
+			ORDER BY d.next_attempt_at, d.id
+			LIMIT ?
+		`,
+		webhooksAttempt: `
+			INSERT INTO webhook_attempts (delivery_id, attempt, attempted_at, response_code, error, duration_ms)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`,
+		webhooksReschedule: `UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ? WHERE id = ?`,
+		webhooksDeliveries: `
+			SELECT id, webhook_id, event_id, event_type, status, created_at, next_attempt_at
+			FROM webhook_deliveries
+			WHERE webhook_id = ?
+		`,
+		webhooksAttempts: `SELECT delivery_id, attempt, attempted_at, response_code, error, duration_ms FROM webhook_attempts`,
+		// Subscribing again keeps the original subscription, but takes the
+		// customer's current brand and jurisdiction.
+		remindersSubscribe: `
+			INSERT INTO reminder_subscriptions (customer, race_id, created_at, brand, jurisdiction)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (customer, race_id) DO UPDATE SET brand = excluded.brand, jurisdiction = excluded.jurisdiction
+		`,
+		remindersSubscription: `SELECT created_at FROM reminder_subscriptions WHERE customer = ? AND race_id = ?`,
+		remindersUnsubscribe:  `DELETE FROM reminder_subscriptions WHERE customer = ? AND race_id = ?`,
+		// Reminders are due for the races starting within the window bound
+		// to the two leading parameters, unless they were already sent for
+		// the race's current start. Like lists, each subscription only sees
+		// the races of its brand that the brand shows.
+		remindersDue: `
+			SELECT s.customer, s.jurisdiction, r.id, r.meeting_id, r.name, r.number, r.advertised_start_time, r.brand
+			FROM reminder_subscriptions s
+			JOIN races r ON r.id = s.race_id
+			LEFT JOIN meetings m ON m.id = r.meeting_id
+			LEFT JOIN race_visibility_overrides o ON o.race_id = r.id AND o.brand = s.brand
+			WHERE datetime(r.advertised_start_time) > ? AND datetime(r.advertised_start_time) <= ?
+				AND r.brand IN ('', s.brand) AND COALESCE(m.brand, '') IN ('', s.brand)
+				AND COALESCE(o.visible, r.visible)
+				AND NOT EXISTS (
+					SELECT 1
+					FROM reminders_sent x
+					WHERE x.customer = s.customer
+						AND x.race_id = s.race_id
+						AND x.offset_seconds = ?
+						AND x.advertised_start_time = datetime(r.advertised_start_time)
+				)
+			ORDER BY datetime(r.advertised_start_time), s.race_id, s.customer
+			LIMIT ?
+		`,
+		remindersSent: `
+			INSERT INTO reminders_sent (customer, race_id, offset_seconds, advertised_start_time, sent_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT DO NOTHING
+		`,
+		// Following again keeps the original entry, but takes the customer's
+		// current brand and jurisdiction.
+		blackbookFollow: `
+			INSERT INTO blackbook (customer, entity_type, entity_id, created_at, brand, jurisdiction)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (customer, entity_type, entity_id) DO UPDATE SET brand = excluded.brand, jurisdiction = excluded.jurisdiction
+		`,
+		blackbookEntry:    `SELECT entity_type, entity_id, created_at FROM blackbook WHERE customer = ? AND entity_type = ? AND entity_id = ?`,
+		blackbookUnfollow: `DELETE FROM blackbook WHERE customer = ? AND entity_type = ? AND entity_id = ?`,
+		blackbookList:     `SELECT entity_type, entity_id, created_at FROM blackbook WHERE customer = ?`,
+		// The followers of an entity entered in the race bound to the leading
+		// parameter, whose brand shows the race.
+		blackbookFollowers: `
+			SELECT b.customer, b.jurisdiction
+			FROM blackbook b
+			JOIN races r ON r.id = ?
+			LEFT JOIN meetings m ON m.id = r.meeting_id
+			LEFT JOIN race_visibility_overrides o ON o.race_id = r.id AND o.brand = b.brand
+			WHERE b.entity_type = ? AND b.entity_id = ?
+				AND r.brand IN ('', b.brand) AND COALESCE(m.brand, '') IN ('', b.brand)
+				AND COALESCE(o.visible, r.visible)
+			ORDER BY b.customer
 		`,
 	}
 }
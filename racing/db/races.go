@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"strings"
 	"sync"
@@ -8,6 +9,8 @@ import (
 
 	"github.com/golang/protobuf/ptypes"
 	_ "github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 
 	"git.neds.sh/matty/entain/racing/proto/racing"
 )
@@ -15,88 +18,333 @@ import (
 // RacesRepo provides repository access to races.
 type RacesRepo interface {
 	// Init will initialise our races repository.
-	Init() error
+	Init(ctx context.Context) error
 
-	// List will return a list of races.
-	List(filter *racing.ListRacesRequestFilter) ([]*racing.Race, error)
+	// List will return a list of the races the brand may see.
+	List(ctx context.Context, brand string, filter *racing.ListRacesRequestFilter) ([]*racing.Race, error)
+
+	// Get will return the race with the given ID, or sql.ErrNoRows when the
+	// brand may not see it.
+	Get(ctx context.Context, brand string, id int64) (*racing.Race, error)
+
+	// BeginImport will start an import of races owned by the brand. The
+	// races written by an import are committed together, or not at all.
+	BeginImport(ctx context.Context, brand string) (RaceImport, error)
+}
+
+// RaceImport writes the races of an import in a single transaction, which
+// holds the database's write lock until it is committed or rolled back. It
+// is rolled back if the context it began with is done first.
+type RaceImport interface {
+	// Write will insert or update races.
+	Write(ctx context.Context, races []*racing.Race) (ImportResult, error)
+
+	// Commit will commit every race written.
+	Commit() error
+
+	// Rollback will discard every race written. It has no effect once the
+	// import is committed.
+	Rollback() error
+}
+
+// ImportResult counts the races created and updated by a write.
+type ImportResult struct {
+	Created int
+	Updated int
+	// Foreign lists the IDs of races that were skipped because they are
+	// owned by another brand.
+	Foreign []int64
 }
 
 type racesRepo struct {
-	db   *sql.DB
-	init sync.Once
+	db           *sql.DB
+	queryTimeout time.Duration
+	seedConfig   SeedConfig
+	init         sync.Once
 }
 
-// NewRacesRepo creates a new races repository.
-func NewRacesRepo(db *sql.DB) RacesRepo {
-	return &racesRepo{db: db}
+// NewRacesRepo creates a new races repository. Each query is bounded by
+// queryTimeout, unless it is zero, and Init seeds the database as described
+// by seedConfig.
+func NewRacesRepo(db *sql.DB, queryTimeout time.Duration, seedConfig SeedConfig) RacesRepo {
+	return &racesRepo{db: db, queryTimeout: queryTimeout, seedConfig: seedConfig}
 }
 
 // Init prepares the race repository dummy data.
-func (r *racesRepo) Init() error {
+func (r *racesRepo) Init(ctx context.Context) error {
 	var err error
 
 	r.init.Do(func() {
 		// For test/example purposes, we seed the DB with some dummy races.
-		err = r.seed()
+		ctx, done := startQuery(ctx, racesSeed, 0)
+		err = r.seed(ctx)
+		done(err)
 	})
 
 	return err
 }
 
-func (r *racesRepo) List(filter *racing.ListRacesRequestFilter) ([]*racing.Race, error) {
+func (r *racesRepo) List(ctx context.Context, brand string, filter *racing.ListRacesRequestFilter) (races []*racing.Race, err error) {
 	var (
-		err   error
 		query string
 		args  []interface{}
+		dates *dateFilter
+		now   = time.Now()
 	)
 
+	ctx, done := startQuery(ctx, racesList, r.queryTimeout)
+	defer func() { done(err) }()
+
 	query = getRaceQueries()[racesList]
 
-	query, args = r.applyFilter(query, filter)
+	query, args, dates, err = r.applyFilter(query, brandArgs(brand), filter, now)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var match func(start time.Time, loc *time.Location) bool
+	if dates != nil {
+		match = func(start time.Time, loc *time.Location) bool {
+			return dates.matches(now, start, loc)
+		}
+	}
+
+	return r.scanRaces(rows, match)
+}
+
+func (r *racesRepo) Get(ctx context.Context, brand string, id int64) (race *racing.Race, err error) {
+	ctx, done := startQuery(ctx, racesGet, r.queryTimeout)
+	defer func() { done(err) }()
+
+	rows, err := r.db.QueryContext(ctx, getRaceQueries()[racesList]+" AND r.id = ?", append(brandArgs(brand), id)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	races, err := r.scanRaces(rows, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(races) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	race = races[0]
+
+	if race.Runners, err = scanRunners(r.db.QueryContext(ctx, getRaceQueries()[runnersList], race.Id)); err != nil {
+		return nil, err
+	}
+
+	return race, nil
+}
+
+func (r *racesRepo) BeginImport(ctx context.Context, brand string) (RaceImport, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := newChangeRecorder(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	upsert, err := tx.PrepareContext(ctx, getRaceQueries()[racesImport])
+	if err != nil {
+		changes.Close()
+		tx.Rollback()
+		return nil, err
+	}
 
-	rows, err := r.db.Query(query, args...)
+	field, err := newFieldWriter(ctx, tx, changes.outbox)
 	if err != nil {
+		upsert.Close()
+		changes.Close()
+		tx.Rollback()
 		return nil, err
 	}
 
-	return r.scanRaces(rows)
+	return &raceImport{
+		tx:           tx,
+		changes:      changes,
+		upsert:       upsert,
+		field:        field,
+		brand:        brand,
+		queryTimeout: r.queryTimeout,
+	}, nil
+}
+
+// raceImport writes the races of an import in a single transaction.
+type raceImport struct {
+	tx           *sql.Tx
+	changes      *changeRecorder
+	upsert       *sql.Stmt
+	field        *fieldWriter
+	brand        string
+	queryTimeout time.Duration
+}
+
+func (i *raceImport) Write(ctx context.Context, races []*racing.Race) (result ImportResult, err error) {
+	ctx, done := startQuery(ctx, racesImport, i.queryTimeout)
+	defer func() { done(err) }()
+
+	for _, race := range races {
+		before, err := i.changes.race(ctx, race.Id)
+		if err != nil {
+			return result, err
+		}
+
+		operation := OperationCreate
+		if before != nil {
+			operation = OperationUpdate
+		}
+
+		if before != nil && before.Brand != i.brand {
+			result.Foreign = append(result.Foreign, race.Id)
+			continue
+		}
+
+		advertisedStart, err := ptypes.Timestamp(race.AdvertisedStartTime)
+		if err != nil {
+			return result, err
+		}
+
+		after, err := storedRace(race, i.brand, advertisedStart)
+		if err != nil {
+			return result, err
+		}
+
+		// Races imported without runners keep their field, which may not
+		// have been drawn yet.
+		if len(after.Runners) == 0 {
+			after.Runners = before.GetRunners()
+		}
+
+		if operation == OperationCreate {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+
+		// Races rewritten unchanged keep the time they last changed, and are
+		// not worth auditing. A change to the field alone is a change too.
+		if !proto.Equal(before, after) {
+			if _, err := i.upsert.ExecContext(
+				ctx,
+				race.Id,
+				race.MeetingId,
+				race.Name,
+				race.Number,
+				race.Visible,
+				advertisedStart.UTC().Format(time.RFC3339),
+				i.brand,
+				time.Now().UTC().Format(time.RFC3339),
+			); err != nil {
+				return result, err
+			}
+
+			if err := i.changes.record(ctx, operation, before, after); err != nil {
+				return result, err
+			}
+
+			if err := i.field.write(ctx, before, after); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (i *raceImport) Commit() error {
+	i.close()
+	return i.tx.Commit()
+}
+
+func (i *raceImport) Rollback() error {
+	i.close()
+	return i.tx.Rollback()
 }
 
-func (r *racesRepo) applyFilter(query string, filter *racing.ListRacesRequestFilter) (string, []interface{}) {
+func (i *raceImport) close() {
+	i.field.Close()
+	i.upsert.Close()
+	i.changes.Close()
+}
+
+// applyFilter extends the brand scoped list query, whose parameters are args,
+// with the filter's conditions and ordering.
+func (r *racesRepo) applyFilter(query string, args []interface{}, filter *racing.ListRacesRequestFilter, now time.Time) (string, []interface{}, *dateFilter, error) {
 	var (
 		clauses []string
-		args    []interface{}
+		dates   *dateFilter
 	)
 
 	if filter == nil {
-		return query, args
+		return query, args, nil, nil
 	}
 
 	if len(filter.MeetingIds) > 0 {
-		clauses = append(clauses, "meeting_id IN ("+strings.Repeat("?,", len(filter.MeetingIds)-1)+"?)")
+		clauses = append(clauses, "r.meeting_id IN ("+strings.Repeat("?,", len(filter.MeetingIds)-1)+"?)")
 
 		for _, meetingID := range filter.MeetingIds {
 			args = append(args, meetingID)
 		}
 	}
 
+	if filter.Visible != nil {
+		clauses = append(clauses, "COALESCE(o.visible, r.visible) = ?")
+		args = append(args, filter.GetVisible())
+	}
+
+	if filter.Date != "" {
+		var err error
+
+		dates, err = newDateFilter(filter.Date, filter.Timezone)
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		from, to := dates.window(now)
+		clauses = append(clauses, "datetime(r.advertised_start_time) >= ? AND datetime(r.advertised_start_time) < ?")
+		args = append(args, from.UTC().Format(sqlTimeLayout), to.UTC().Format(sqlTimeLayout))
+	}
+
 	if len(clauses) != 0 {
-		query += " WHERE " + strings.Join(clauses, " AND ")
+		query += " AND " + strings.Join(clauses, " AND ")
 	}
 
-	return query, args
+	orderBy, err := ParseRaceOrderBy(filter.OrderBy)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return query + orderBy, args, dates, nil
 }
 
+// scanRaces reads the races in rows, skipping those match rejects when it
+// is set.
 func (m *racesRepo) scanRaces(
 	rows *sql.Rows,
+	match func(start time.Time, loc *time.Location) bool,
 ) ([]*racing.Race, error) {
 	var races []*racing.Race
 
 	for rows.Next() {
 		var race racing.Race
 		var advertisedStart time.Time
+		var updatedAt sql.NullTime
 
-		if err := rows.Scan(&race.Id, &race.MeetingId, &race.Name, &race.Number, &race.Visible, &advertisedStart); err != nil {
+		if err := rows.Scan(&race.Id, &race.MeetingId, &race.Name, &race.Number, &race.Visible, &advertisedStart, &race.VenueTimezone, &race.Brand, &updatedAt); err != nil {
 			if err == sql.ErrNoRows {
 				return nil, nil
 			}
@@ -111,8 +359,39 @@ func (m *racesRepo) scanRaces(
 
 		race.AdvertisedStartTime = ts
 
+		if updatedAt.Valid {
+			if race.UpdatedAt, err = ptypes.TimestampProto(updatedAt.Time); err != nil {
+				return nil, err
+			}
+		}
+
+		loc, err := LoadTimezone(race.VenueTimezone)
+		if err != nil {
+			zap.L().Warn("meeting has an invalid venue timezone", zap.Int64("meeting_id", race.MeetingId), zap.Error(err))
+
+			race.VenueTimezone = defaultTimezone
+			loc = time.UTC
+		}
+
+		if match != nil && !match(advertisedStart, loc) {
+			continue
+		}
+
+		local := advertisedStart.In(loc)
+		race.LocalStartTime = local.Format(time.RFC3339)
+		race.RaceDate = local.Format(dateLayout)
+
 		races = append(races, &race)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
 	return races, nil
 }
+
+// brandArgs binds brand to the parameters of the brand scoped list query.
+func brandArgs(brand string) []interface{} {
+	return []interface{}{brand, brand, brand}
+}
@@ -0,0 +1,228 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/events"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Reminder is a reminder due to be sent to a customer subscribed to a race.
+type Reminder struct {
+	Customer string
+	Race     *racing.Race
+	// Offset is how long before the race's advertised start the reminder is
+	// for.
+	Offset time.Duration
+	// Jurisdiction is the customer's region when they subscribed, or "" if
+	// unknown.
+	Jurisdiction string
+	// Withheld reminders are recorded as sent without being queued, e.g.
+	// for races the customer's jurisdiction may not see.
+	Withheld bool
+}
+
+// RemindersRepo provides repository access to race reminder subscriptions.
+//
+// Reminders are not scheduled ahead of time. Instead, they fall due as the
+// stored advertised start of the race approaches, so that rescheduling a race
+// reschedules its reminders, and a record of the reminders sent for each
+// start keeps them from being sent twice, even across restarts. Subscriptions
+// keep the customer's brand and jurisdiction, so that reminders are only sent
+// for races the customer may still see.
+type RemindersRepo interface {
+	// Init will create the reminder tables.
+	Init(ctx context.Context) error
+
+	// Subscribe will subscribe the customer of the given brand and
+	// jurisdiction to reminders of a race, returning when they subscribed.
+	Subscribe(ctx context.Context, customer, brand, jurisdiction string, raceID int64) (time.Time, error)
+
+	// Unsubscribe will stop reminders of a race for the customer.
+	Unsubscribe(ctx context.Context, customer string, raceID int64) error
+
+	// Due will return up to limit unsent reminders for the given offset of
+	// visible races starting in the window (now+after, now+offset].
+	// Reminders whose window has passed are never due.
+	Due(ctx context.Context, now time.Time, offset, after time.Duration, limit int) ([]Reminder, error)
+
+	// Send will queue a race.reminder event for each reminder that is not
+	// withheld, recording that each was sent.
+	Send(ctx context.Context, reminders []Reminder) error
+}
+
+type remindersRepo struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+}
+
+// NewRemindersRepo creates a new reminders repository. Each query is bounded
+// by queryTimeout, unless it is zero.
+func NewRemindersRepo(db *sql.DB, queryTimeout time.Duration) RemindersRepo {
+	return &remindersRepo{db: db, queryTimeout: queryTimeout}
+}
+
+func (r *remindersRepo) Init(ctx context.Context) error {
+	for _, statement := range []string{
+		`CREATE TABLE IF NOT EXISTS reminder_subscriptions (customer TEXT NOT NULL, race_id INTEGER NOT NULL, created_at TEXT NOT NULL, PRIMARY KEY (customer, race_id))`,
+		`CREATE INDEX IF NOT EXISTS reminder_subscriptions_race_id ON reminder_subscriptions (race_id)`,
+		`CREATE TABLE IF NOT EXISTS reminders_sent (customer TEXT NOT NULL, race_id INTEGER NOT NULL, offset_seconds INTEGER NOT NULL, advertised_start_time TEXT NOT NULL, sent_at TEXT NOT NULL, PRIMARY KEY (customer, race_id, offset_seconds, advertised_start_time))`,
+	} {
+		if _, err := r.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	for _, column := range []string{"brand", "jurisdiction"} {
+		if err := addColumn(ctx, r.db, "reminder_subscriptions", column, `TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *remindersRepo) Subscribe(ctx context.Context, customer, brand, jurisdiction string, raceID int64) (createdAt time.Time, err error) {
+	ctx, done := startQuery(ctx, remindersSubscribe, r.queryTimeout)
+	defer func() { done(err) }()
+
+	now := time.Now().UTC().Format(auditTimeLayout)
+
+	if _, err := r.db.ExecContext(ctx, getRaceQueries()[remindersSubscribe], customer, raceID, now, brand, jurisdiction); err != nil {
+		return time.Time{}, err
+	}
+
+	var stored string
+	if err := r.db.QueryRowContext(ctx, getRaceQueries()[remindersSubscription], customer, raceID).Scan(&stored); err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Parse(auditTimeLayout, stored)
+}
+
+func (r *remindersRepo) Unsubscribe(ctx context.Context, customer string, raceID int64) (err error) {
+	ctx, done := startQuery(ctx, remindersUnsubscribe, r.queryTimeout)
+	defer func() { done(err) }()
+
+	_, err = r.db.ExecContext(ctx, getRaceQueries()[remindersUnsubscribe], customer, raceID)
+
+	return err
+}
+
+func (r *remindersRepo) Due(ctx context.Context, now time.Time, offset, after time.Duration, limit int) (due []Reminder, err error) {
+	ctx, done := startQuery(ctx, remindersDue, r.queryTimeout)
+	defer func() { done(err) }()
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		getRaceQueries()[remindersDue],
+		now.Add(after).UTC().Format(sqlTimeLayout),
+		now.Add(offset).UTC().Format(sqlTimeLayout),
+		int64(offset/time.Second),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			reminder        = Reminder{Race: &racing.Race{Visible: true}, Offset: offset}
+			race            = reminder.Race
+			advertisedStart time.Time
+		)
+
+		if err := rows.Scan(&reminder.Customer, &reminder.Jurisdiction, &race.Id, &race.MeetingId, &race.Name, &race.Number, &advertisedStart, &race.Brand); err != nil {
+			return nil, err
+		}
+
+		if race.AdvertisedStartTime, err = ptypes.TimestampProto(advertisedStart.UTC()); err != nil {
+			return nil, err
+		}
+
+		due = append(due, reminder)
+	}
+
+	return due, rows.Err()
+}
+
+func (r *remindersRepo) Send(ctx context.Context, reminders []Reminder) (err error) {
+	ctx, done := startQuery(ctx, remindersSent, r.queryTimeout)
+	defer func() { done(err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sent, err := tx.PrepareContext(ctx, getRaceQueries()[remindersSent])
+	if err != nil {
+		return err
+	}
+	defer sent.Close()
+
+	outbox, err := newOutboxWriter(ctx, tx)
+	if err != nil {
+		return err
+	}
+	defer outbox.Close()
+
+	now := time.Now()
+
+	for _, reminder := range reminders {
+		advertisedStart, err := ptypes.Timestamp(reminder.Race.AdvertisedStartTime)
+		if err != nil {
+			return err
+		}
+
+		res, err := sent.ExecContext(
+			ctx,
+			reminder.Customer,
+			reminder.Race.Id,
+			int64(reminder.Offset/time.Second),
+			advertisedStart.UTC().Format(sqlTimeLayout),
+			now.UTC().Format(auditTimeLayout),
+		)
+		if err != nil {
+			return err
+		}
+
+		// A reminder already sent is not sent again.
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 || reminder.Withheld {
+			continue
+		}
+
+		event := events.Event{
+			Type:       events.RaceReminder,
+			RaceID:     reminder.Race.Id,
+			Brand:      reminder.Race.Brand,
+			OccurredAt: now,
+			Customer:   reminder.Customer,
+		}
+
+		if event.Race, err = protojson.Marshal(reminder.Race); err != nil {
+			return err
+		}
+
+		if event.Data, err = json.Marshal(events.ReminderData{OffsetSeconds: int64(reminder.Offset / time.Second)}); err != nil {
+			return err
+		}
+
+		if err := outbox.add(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/jurisdiction"
+)
+
+// RulesRepo provides repository access to jurisdiction rules. Its table is
+// created when the races repository is initialised.
+type RulesRepo interface {
+	// List will return every jurisdiction rule.
+	List(ctx context.Context) ([]jurisdiction.Rule, error)
+}
+
+type rulesRepo struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+}
+
+// NewRulesRepo creates a new jurisdiction rules repository. Each query is
+// bounded by queryTimeout, unless it is zero.
+func NewRulesRepo(db *sql.DB, queryTimeout time.Duration) RulesRepo {
+	return &rulesRepo{db: db, queryTimeout: queryTimeout}
+}
+
+func (r *rulesRepo) List(ctx context.Context) (rules []jurisdiction.Rule, err error) {
+	ctx, done := startQuery(ctx, rulesList, r.queryTimeout)
+	defer func() { done(err) }()
+
+	return scanRules(r.db.QueryContext(ctx, getRaceQueries()[rulesList]))
+}
+
+// scanRules reads the jurisdiction rules in rows.
+func scanRules(rows *sql.Rows, err error) ([]jurisdiction.Rule, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []jurisdiction.Rule
+
+	for rows.Next() {
+		var rule jurisdiction.Rule
+
+		if err := rows.Scan(&rule.MeetingID, &rule.RaceID, &rule.Region, &rule.Effect); err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
@@ -0,0 +1,266 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/events"
+	"git.neds.sh/matty/entain/racing/jurisdiction"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// createRunnersSchema creates the table holding the field of each race.
+func createRunnersSchema(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS runners (race_id INTEGER NOT NULL, number INTEGER NOT NULL, runner_id TEXT NOT NULL, jockey_id TEXT NOT NULL, trainer_id TEXT NOT NULL, PRIMARY KEY (race_id, number))`)
+
+	return err
+}
+
+// fieldWriter replaces the fields of races in a transaction, matching the
+// runners, jockeys and trainers newly entered against the blackbook so that
+// their followers are notified. Like reads, followers are only told of races
+// their brand shows and their jurisdiction allows.
+type fieldWriter struct {
+	replace   *sql.Stmt
+	insert    *sql.Stmt
+	followers *sql.Stmt
+	rules     *sql.Stmt
+	outbox    *outboxWriter
+
+	// policy is loaded when first needed, once per transaction.
+	policy *jurisdiction.Policy
+}
+
+func newFieldWriter(ctx context.Context, tx *sql.Tx, outbox *outboxWriter) (*fieldWriter, error) {
+	w := &fieldWriter{outbox: outbox}
+
+	for _, s := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&w.replace, runnersReplace},
+		{&w.insert, runnersInsert},
+		{&w.followers, blackbookFollowers},
+		{&w.rules, rulesList},
+	} {
+		stmt, err := tx.PrepareContext(ctx, getRaceQueries()[s.query])
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+
+		*s.stmt = stmt
+	}
+
+	return w, nil
+}
+
+func (w *fieldWriter) Close() error {
+	for _, stmt := range []*sql.Stmt{w.replace, w.insert, w.followers, w.rules} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+
+	return nil
+}
+
+// write replaces the field of a race changed from before to after, either of
+// which may be nil, and notifies the followers of each entity that was not
+// already entered in the race.
+func (w *fieldWriter) write(ctx context.Context, before, after *racing.Race) error {
+	previous, runners := before.GetRunners(), after.GetRunners()
+
+	if equalRunners(previous, runners) {
+		return nil
+	}
+
+	if _, err := w.replace.ExecContext(ctx, after.Id); err != nil {
+		return err
+	}
+
+	for _, runner := range runners {
+		if _, err := w.insert.ExecContext(ctx, after.Id, runner.Number, runner.RunnerId, runner.JockeyId, runner.TrainerId); err != nil {
+			return err
+		}
+	}
+
+	raceJSON, err := protojson.Marshal(after)
+	if err != nil {
+		return err
+	}
+
+	already := make(map[entity]bool)
+	for _, runner := range previous {
+		for _, e := range entities(runner) {
+			already[e] = true
+		}
+	}
+
+	now := time.Now()
+
+	for _, runner := range runners {
+		for _, e := range entities(runner) {
+			if already[e] {
+				continue
+			}
+			already[e] = true
+
+			if err := w.notify(ctx, e, runner, after, raceJSON, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// notify queues an entity.entered event for each follower of e who may see
+// race.
+func (w *fieldWriter) notify(ctx context.Context, e entity, runner *racing.Runner, race *racing.Race, raceJSON []byte, at time.Time) error {
+	rows, err := w.followers.QueryContext(ctx, race.Id, e.entityType, e.id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var customers, jurisdictions []string
+
+	for rows.Next() {
+		var customer, jurisdiction string
+		if err := rows.Scan(&customer, &jurisdiction); err != nil {
+			return err
+		}
+
+		customers = append(customers, customer)
+		jurisdictions = append(jurisdictions, jurisdiction)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(customers) == 0 {
+		return nil
+	}
+
+	policy, err := w.loadPolicy(ctx)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(events.EntityEnteredData{EntityType: e.entityType, EntityID: e.id, RunnerNumber: runner.Number})
+	if err != nil {
+		return err
+	}
+
+	for i, customer := range customers {
+		if policy.Check(race.Id, race.MeetingId, jurisdictions[i]) != "" {
+			continue
+		}
+
+		if err := w.outbox.add(ctx, events.Event{
+			Type:       events.EntityEntered,
+			RaceID:     race.Id,
+			Brand:      race.Brand,
+			OccurredAt: at,
+			Race:       raceJSON,
+			Customer:   customer,
+			Data:       data,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadPolicy returns the jurisdiction policy, reading the rules on first use.
+func (w *fieldWriter) loadPolicy(ctx context.Context) (*jurisdiction.Policy, error) {
+	if w.policy != nil {
+		return w.policy, nil
+	}
+
+	rules, err := scanRules(w.rules.QueryContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	w.policy = jurisdiction.NewPolicy(rules)
+
+	return w.policy, nil
+}
+
+// entity identifies a runner, jockey or trainer customers may follow.
+type entity struct {
+	entityType string
+	id         string
+}
+
+// entities returns the entities entered with runner.
+func entities(runner *racing.Runner) []entity {
+	all := []entity{{EntityRunner, runner.RunnerId}}
+
+	if runner.JockeyId != "" {
+		all = append(all, entity{EntityJockey, runner.JockeyId})
+	}
+
+	if runner.TrainerId != "" {
+		all = append(all, entity{EntityTrainer, runner.TrainerId})
+	}
+
+	return all
+}
+
+// sortedRunners returns a copy of runners ordered by number.
+func sortedRunners(runners []*racing.Runner) []*racing.Runner {
+	if len(runners) == 0 {
+		return nil
+	}
+
+	sorted := append([]*racing.Runner(nil), runners...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Number < sorted[j].Number })
+
+	return sorted
+}
+
+func equalRunners(a, b []*racing.Runner) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !proto.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// scanRunners reads the runners in rows.
+func scanRunners(rows *sql.Rows, err error) ([]*racing.Runner, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runners []*racing.Runner
+
+	for rows.Next() {
+		var runner racing.Runner
+
+		if err := rows.Scan(&runner.Number, &runner.RunnerId, &runner.JockeyId, &runner.TrainerId); err != nil {
+			return nil, err
+		}
+
+		runners = append(runners, &runner)
+	}
+
+	return runners, rows.Err()
+}
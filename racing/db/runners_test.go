@@ -0,0 +1,313 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/events"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// importRace imports race for the neds brand in its own transaction.
+func importRace(t *testing.T, repo RacesRepo, race *racing.Race) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	imp, err := repo.BeginImport(ctx, "neds")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := imp.Write(ctx, []*racing.Race{race}); err != nil {
+		imp.Rollback()
+		t.Fatal(err)
+	}
+
+	if err := imp.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// entered describes the customers notified by the pending entity.entered
+// events, and marks every pending event published.
+func entered(t *testing.T, outbox OutboxRepo) []string {
+	t.Helper()
+
+	ctx := context.Background()
+
+	pending, err := outbox.Pending(ctx, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		got []string
+		ids []int64
+	)
+
+	for _, event := range pending {
+		ids = append(ids, event.ID)
+
+		if event.Type != events.EntityEntered {
+			continue
+		}
+
+		var data events.EntityEnteredData
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+
+		race, err := event.DecodeRace()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if race.Id != event.RaceID || len(race.Runners) == 0 {
+			t.Errorf("got event race %v, want race %d with its field", race, event.RaceID)
+		}
+
+		got = append(got, event.Customer+" "+data.EntityType+" "+data.EntityID)
+	}
+
+	if err := outbox.MarkPublished(ctx, ids); err != nil {
+		t.Fatal(err)
+	}
+
+	return got
+}
+
+func TestFollowedEntityEntered(t *testing.T) {
+	ctx := context.Background()
+
+	racingDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "racing.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer racingDB.Close()
+
+	races := NewRacesRepo(racingDB, 0, SeedConfig{})
+	if err := races.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	blackbook := NewBlackbookRepo(racingDB, 0)
+	if err := blackbook.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, follow := range []struct{ customer, entityType, entityID string }{
+		{"alice", EntityRunner, "winx"},
+		{"alice", EntityJockey, "h-bowman"},
+		{"bob", EntityTrainer, "c-waller"},
+		{"bob", EntityRunner, "black-caviar"},
+	} {
+		if _, err := blackbook.Follow(ctx, follow.customer, "neds", "", follow.entityType, follow.entityID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outbox := NewOutboxRepo(racingDB, 0)
+	race := &racing.Race{Id: 1, MeetingId: 1, Name: "Cox Plate", Number: 9, Visible: true, AdvertisedStartTime: timestamppb.New(time.Now().Add(time.Hour))}
+
+	// Races imported without runners notify nobody.
+	importRace(t, races, race)
+	if got := entered(t, outbox); got != nil {
+		t.Fatalf("got %v, want no entries", got)
+	}
+
+	race.Runners = []*racing.Runner{
+		{Number: 2, RunnerId: "verry-elleegant", TrainerId: "c-waller"},
+		{Number: 1, RunnerId: "winx", JockeyId: "h-bowman", TrainerId: "c-waller"},
+	}
+	importRace(t, races, race)
+
+	want := []string{"alice runner winx", "alice jockey h-bowman", "bob trainer c-waller"}
+	if got := entered(t, outbox); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got entries %v, want %v", got, want)
+	}
+
+	// Entities already entered are not notified again.
+	importRace(t, races, race)
+	if got := entered(t, outbox); got != nil {
+		t.Fatalf("got %v after importing the same field, want no entries", got)
+	}
+
+	race.Runners = append(race.Runners, &racing.Runner{Number: 3, RunnerId: "black-caviar", JockeyId: "h-bowman"})
+	importRace(t, races, race)
+
+	want = []string{"bob runner black-caviar"}
+	if got := entered(t, outbox); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got entries %v, want %v", got, want)
+	}
+
+	stored, err := races.Get(ctx, "neds", race.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var numbers []int64
+	for _, runner := range stored.Runners {
+		numbers = append(numbers, runner.Number)
+	}
+
+	if !reflect.DeepEqual(numbers, []int64{1, 2, 3}) {
+		t.Errorf("got runners %v, want numbers 1, 2 and 3", stored.Runners)
+	}
+}
+
+func TestHiddenRaceEntityEntered(t *testing.T) {
+	ctx := context.Background()
+
+	racingDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "racing.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer racingDB.Close()
+
+	races := NewRacesRepo(racingDB, 0, SeedConfig{})
+	if err := races.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	blackbook := NewBlackbookRepo(racingDB, 0)
+	if err := blackbook.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, follow := range []struct{ customer, brand, jurisdiction string }{
+		{"alice", "neds", "AU-NSW"},
+		{"bob", "ladbrokes", "AU-NSW"},
+		{"carol", "neds", "AU-VIC"},
+	} {
+		if _, err := blackbook.Follow(ctx, follow.customer, follow.brand, follow.jurisdiction, EntityRunner, "winx"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Race 2 is hidden by neds, and race 3 is blocked in New South Wales.
+	for _, statement := range []string{
+		`INSERT INTO race_visibility_overrides (brand, race_id, visible) VALUES ('neds', 2, 0)`,
+		`INSERT INTO jurisdiction_rules (race_id, region, effect) VALUES (3, 'AU-NSW', 'block')`,
+	} {
+		if _, err := racingDB.ExecContext(ctx, statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outbox := NewOutboxRepo(racingDB, 0)
+
+	for _, test := range []struct {
+		name    string
+		id      int64
+		visible bool
+		want    []string
+	}{
+		{"hidden", 1, false, nil},
+		{"hidden by the brand", 2, true, nil},
+		{"blocked in a jurisdiction", 3, true, []string{"carol runner winx"}},
+		{"visible", 4, true, []string{"alice runner winx", "carol runner winx"}},
+	} {
+		importRace(t, races, &racing.Race{
+			Id:                  test.id,
+			MeetingId:           1,
+			Name:                test.name,
+			Number:              test.id,
+			Visible:             test.visible,
+			AdvertisedStartTime: timestamppb.New(time.Now().Add(time.Hour)),
+			Runners:             []*racing.Runner{{Number: 1, RunnerId: "winx"}},
+		})
+
+		if got := entered(t, outbox); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got entries %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFieldChangeRecorded(t *testing.T) {
+	ctx := context.Background()
+
+	racingDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "racing.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer racingDB.Close()
+
+	races := NewRacesRepo(racingDB, 0, SeedConfig{})
+	if err := races.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := NewOutboxRepo(racingDB, 0)
+	race := &racing.Race{Id: 1, MeetingId: 1, Name: "Cox Plate", Number: 9, AdvertisedStartTime: timestamppb.New(time.Now().Add(time.Hour))}
+
+	importRace(t, races, race)
+	entered(t, outbox)
+
+	if _, err := racingDB.ExecContext(ctx, `UPDATE races SET updated_at = '2000-01-01T00:00:00Z'`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the field changes.
+	race.Runners = []*racing.Runner{
+		{Number: 2, RunnerId: "verry-elleegant"},
+		{Number: 1, RunnerId: "winx"},
+	}
+	importRace(t, races, race)
+
+	pending, err := outbox.Pending(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pending) != 1 || pending[0].Type != events.RaceUpdated {
+		t.Fatalf("got events %v, want a single %s", pending, events.RaceUpdated)
+	}
+
+	updated, err := pending[0].DecodeRace()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(updated.Runners) != 2 || updated.Runners[0].RunnerId != "winx" {
+		t.Errorf("got updated race %v, want its field ordered by number", updated)
+	}
+
+	audited, err := NewAuditRepo(racingDB, 0).List(ctx, "neds", AuditFilter{RaceID: race.Id}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(audited) != 1 || audited[0].Operation != OperationUpdate || len(audited[0].Before.GetRunners()) != 0 || len(audited[0].After.GetRunners()) != 2 {
+		t.Errorf("got audit events %v, want the field's update", audited)
+	}
+
+	stored, err := races.Get(ctx, "neds", race.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.UpdatedAt.AsTime().Year() == 2000 {
+		t.Errorf("got race updated at %v, want the time of the field's change", stored.UpdatedAt.AsTime())
+	}
+
+	entered(t, outbox)
+
+	// Races imported again unchanged, or without runners, are not updated.
+	importRace(t, races, race)
+	importRace(t, races, &racing.Race{Id: race.Id, MeetingId: race.MeetingId, Name: race.Name, Number: race.Number, AdvertisedStartTime: race.AdvertisedStartTime})
+
+	if pending, err := outbox.Pending(ctx, 10); err != nil || len(pending) != 0 {
+		t.Fatalf("got events %v and error %v, want none", pending, err)
+	}
+
+	if stored, err = races.Get(ctx, "neds", race.Id); err != nil || len(stored.Runners) != 2 {
+		t.Fatalf("got race %v and error %v, want its field kept", stored, err)
+	}
+}
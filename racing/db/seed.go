@@ -0,0 +1,346 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.neds.sh/matty/entain/common/claims"
+	"git.neds.sh/matty/entain/racing/jurisdiction"
+	"gopkg.in/yaml.v3"
+	"syreclabs.com/go/faker"
+)
+
+// SeedConfig controls the dummy data written when the races repository is
+// initialised.
+type SeedConfig struct {
+	// Seed makes the generated races reproducible. A zero seed picks one at
+	// random, which is logged so the run can be repeated.
+	Seed int64
+	// Meetings and RacesPerMeeting control how many races are generated.
+	Meetings        int
+	RacesPerMeeting int
+	// Start is the time races are spread around (now when zero). Races start
+	// between SpreadBefore before and SpreadAfter after it.
+	Start        time.Time
+	SpreadBefore time.Duration
+	SpreadAfter  time.Duration
+	// Fixtures lists JSON or YAML files to load races from. When set, they
+	// replace the generated races.
+	Fixtures []string
+	// Reset removes existing races before seeding.
+	Reset bool
+}
+
+// venueTimezones are the timezones generated meetings are held in.
+var venueTimezones = []string{
+	"Australia/Sydney",
+	"Australia/Melbourne",
+	"Australia/Brisbane",
+	"Australia/Adelaide",
+	"Australia/Perth",
+	"Pacific/Auckland",
+	"Asia/Hong_Kong",
+	"Europe/London",
+	"America/New_York",
+}
+
+// seedData is everything written by the seeder.
+type seedData struct {
+	meetings  []seedMeeting
+	races     []seedRace
+	rules     []jurisdiction.Rule
+	overrides []seedOverride
+}
+
+// seedMeeting is a meeting to be written by the seeder.
+type seedMeeting struct {
+	id            int64
+	name          string
+	venueTimezone string
+	brand         string
+}
+
+// seedRace is a race to be written by the seeder.
+type seedRace struct {
+	id                  int64
+	meetingID           int64
+	name                string
+	number              int64
+	visible             bool
+	advertisedStartTime time.Time
+	brand               string
+}
+
+// seedOverride overrides the visibility of a race for a brand.
+type seedOverride struct {
+	brand   string
+	raceID  int64
+	visible bool
+}
+
+// generateRaces returns cfg.Meetings random meetings, each with
+// cfg.RacesPerMeeting random races.
+func generateRaces(cfg SeedConfig, seed int64, start time.Time) ([]seedMeeting, []seedRace) {
+	rng := rand.New(rand.NewSource(seed))
+	faker.Seed(seed)
+
+	spread := int64(cfg.SpreadBefore + cfg.SpreadAfter)
+	meetings := make([]seedMeeting, 0, cfg.Meetings)
+	races := make([]seedRace, 0, cfg.Meetings*cfg.RacesPerMeeting)
+
+	for meeting := 1; meeting <= cfg.Meetings; meeting++ {
+		meetings = append(meetings, seedMeeting{
+			id:            int64(meeting),
+			name:          faker.Address().City(),
+			venueTimezone: venueTimezones[rng.Intn(len(venueTimezones))],
+		})
+
+		for number := 1; number <= cfg.RacesPerMeeting; number++ {
+			offset := -cfg.SpreadBefore
+			if spread > 0 {
+				offset += time.Duration(rng.Int63n(spread))
+			}
+
+			races = append(races, seedRace{
+				id:                  int64(len(races) + 1),
+				meetingID:           int64(meeting),
+				name:                faker.Team().Name(),
+				number:              int64(number),
+				visible:             rng.Intn(2) == 1,
+				advertisedStartTime: start.Add(offset).Truncate(time.Second),
+			})
+		}
+	}
+
+	return meetings, races
+}
+
+// fixtureFile is the layout of a fixture file.
+type fixtureFile struct {
+	Meetings          []fixtureMeeting  `json:"meetings" yaml:"meetings"`
+	Races             []fixtureRace     `json:"races" yaml:"races"`
+	JurisdictionRules []fixtureRule     `json:"jurisdiction_rules" yaml:"jurisdiction_rules"`
+	Overrides         []fixtureOverride `json:"visibility_overrides" yaml:"visibility_overrides"`
+}
+
+// fixtureMeeting is a meeting in a fixture file. Races of meetings that are
+// not listed are taken to be held in UTC. Meetings and races without a brand
+// are shared by every brand.
+type fixtureMeeting struct {
+	ID            int64  `json:"id" yaml:"id"`
+	Name          string `json:"name" yaml:"name"`
+	VenueTimezone string `json:"venue_timezone" yaml:"venue_timezone"`
+	Brand         string `json:"brand" yaml:"brand"`
+}
+
+// fixtureRace is a race in a fixture file. Its start is either an absolute
+// RFC 3339 time, or a duration such as "-30m" relative to the seed start,
+// which keeps fixtures useful as time passes.
+type fixtureRace struct {
+	ID                  int64  `json:"id" yaml:"id"`
+	MeetingID           int64  `json:"meeting_id" yaml:"meeting_id"`
+	Name                string `json:"name" yaml:"name"`
+	Number              int64  `json:"number" yaml:"number"`
+	Visible             bool   `json:"visible" yaml:"visible"`
+	AdvertisedStartTime string `json:"advertised_start_time" yaml:"advertised_start_time"`
+	StartsIn            string `json:"starts_in" yaml:"starts_in"`
+	Brand               string `json:"brand" yaml:"brand"`
+}
+
+// fixtureRule is a jurisdiction rule in a fixture file, applying to either a
+// meeting or a single race.
+type fixtureRule struct {
+	MeetingID int64  `json:"meeting_id" yaml:"meeting_id"`
+	RaceID    int64  `json:"race_id" yaml:"race_id"`
+	Region    string `json:"region" yaml:"region"`
+	Effect    string `json:"effect" yaml:"effect"`
+}
+
+// fixtureOverride overrides the visibility of a race for a brand.
+type fixtureOverride struct {
+	Brand   string `json:"brand" yaml:"brand"`
+	RaceID  int64  `json:"race_id" yaml:"race_id"`
+	Visible bool   `json:"visible" yaml:"visible"`
+}
+
+// loadFixtures reads everything to seed from the given fixture files.
+func loadFixtures(files []string, start time.Time) (*seedData, error) {
+	var data seedData
+
+	for _, file := range files {
+		fixtures, err := readFixtureFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, f := range fixtures.Meetings {
+			meeting, err := f.toSeedMeeting()
+			if err != nil {
+				return nil, fmt.Errorf("fixture %s: meetings[%d]: %w", file, i, err)
+			}
+
+			data.meetings = append(data.meetings, meeting)
+		}
+
+		for i, f := range fixtures.Races {
+			race, err := f.toSeedRace(start)
+			if err != nil {
+				return nil, fmt.Errorf("fixture %s: races[%d]: %w", file, i, err)
+			}
+
+			data.races = append(data.races, race)
+		}
+
+		for i, f := range fixtures.JurisdictionRules {
+			rule, err := f.toRule()
+			if err != nil {
+				return nil, fmt.Errorf("fixture %s: jurisdiction_rules[%d]: %w", file, i, err)
+			}
+
+			data.rules = append(data.rules, rule)
+		}
+
+		for i, f := range fixtures.Overrides {
+			override, err := f.toSeedOverride()
+			if err != nil {
+				return nil, fmt.Errorf("fixture %s: visibility_overrides[%d]: %w", file, i, err)
+			}
+
+			data.overrides = append(data.overrides, override)
+		}
+	}
+
+	return &data, nil
+}
+
+func readFixtureFile(file string) (*fixtureFile, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var fixtures fixtureFile
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&fixtures)
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&fixtures)
+	default:
+		return nil, fmt.Errorf("fixture %s: unsupported format, expected .json, .yaml or .yml", file)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("fixture %s: %w", file, err)
+	}
+
+	return &fixtures, nil
+}
+
+func (f fixtureMeeting) toSeedMeeting() (seedMeeting, error) {
+	meeting := seedMeeting{id: f.ID, name: f.Name, venueTimezone: f.VenueTimezone, brand: f.Brand}
+
+	if f.ID <= 0 {
+		return meeting, fmt.Errorf("id must be positive")
+	}
+
+	if err := claims.ValidateBrand(f.Brand); f.Brand != "" && err != nil {
+		return meeting, fmt.Errorf("brand: %w", err)
+	}
+
+	if _, err := LoadTimezone(f.VenueTimezone); err != nil {
+		return meeting, fmt.Errorf("venue_timezone: %w", err)
+	}
+
+	return meeting, nil
+}
+
+func (f fixtureRace) toSeedRace(start time.Time) (seedRace, error) {
+	race := seedRace{
+		id:        f.ID,
+		meetingID: f.MeetingID,
+		name:      f.Name,
+		number:    f.Number,
+		visible:   f.Visible,
+		brand:     f.Brand,
+	}
+
+	if err := claims.ValidateBrand(f.Brand); f.Brand != "" && err != nil {
+		return race, fmt.Errorf("brand: %w", err)
+	}
+
+	switch {
+	case f.ID <= 0:
+		return race, fmt.Errorf("id must be positive")
+	case f.MeetingID <= 0:
+		return race, fmt.Errorf("meeting_id must be positive")
+	case f.Name == "":
+		return race, fmt.Errorf("name is required")
+	case (f.AdvertisedStartTime == "") == (f.StartsIn == ""):
+		return race, fmt.Errorf("exactly one of advertised_start_time and starts_in is required")
+	}
+
+	if f.StartsIn != "" {
+		offset, err := time.ParseDuration(f.StartsIn)
+		if err != nil {
+			return race, fmt.Errorf("starts_in: %w", err)
+		}
+
+		race.advertisedStartTime = start.Add(offset).Truncate(time.Second)
+
+		return race, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, f.AdvertisedStartTime)
+	if err != nil {
+		return race, fmt.Errorf("advertised_start_time: %w", err)
+	}
+
+	race.advertisedStartTime = t
+
+	return race, nil
+}
+
+func (f fixtureRule) toRule() (jurisdiction.Rule, error) {
+	rule := jurisdiction.Rule{MeetingID: f.MeetingID, RaceID: f.RaceID}
+
+	if (f.MeetingID > 0) == (f.RaceID > 0) {
+		return rule, fmt.Errorf("exactly one of meeting_id and race_id is required")
+	}
+
+	var err error
+
+	if rule.Region, err = claims.ParseJurisdiction(f.Region); err != nil {
+		return rule, fmt.Errorf("region: %w", err)
+	}
+
+	if rule.Effect, err = jurisdiction.ParseEffect(f.Effect); err != nil {
+		return rule, fmt.Errorf("effect: %w", err)
+	}
+
+	return rule, nil
+}
+
+func (f fixtureOverride) toSeedOverride() (seedOverride, error) {
+	override := seedOverride{brand: f.Brand, raceID: f.RaceID, visible: f.Visible}
+
+	if f.RaceID <= 0 {
+		return override, fmt.Errorf("race_id must be positive")
+	}
+
+	if err := claims.ValidateBrand(f.Brand); err != nil {
+		return override, fmt.Errorf("brand: %w", err)
+	}
+
+	return override, nil
+}
@@ -0,0 +1,384 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/events"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Statuses of webhook deliveries.
+const (
+	DeliveryPending      = "pending"
+	DeliverySucceeded    = "succeeded"
+	DeliveryDeadLettered = "dead_lettered"
+)
+
+// Webhook is a subscription of an endpoint to race events.
+type Webhook struct {
+	ID  int64
+	URL string
+	// EventTypes selects the events delivered, or every event when empty.
+	EventTypes []string
+	Secret     string
+	// Owner is the subject that registered the webhook.
+	Owner string
+	// Brand limits deliveries to the events the brand may see.
+	Brand     string
+	CreatedAt time.Time
+}
+
+// Delivery is an event due to be delivered to a webhook.
+type Delivery struct {
+	ID        int64
+	WebhookID int64
+	URL       string
+	Secret    string
+	EventID   int64
+	EventType string
+	Payload   []byte
+	// Attempts counts the attempts made so far.
+	Attempts int
+}
+
+// Attempt is the outcome of a single try at delivering an event.
+type Attempt struct {
+	DeliveryID   int64
+	Attempt      int
+	AttemptedAt  time.Time
+	ResponseCode int
+	Error        string
+	Duration     time.Duration
+}
+
+// WebhooksRepo provides repository access to webhooks and their deliveries.
+type WebhooksRepo interface {
+	// Init will create the webhook tables.
+	Init(ctx context.Context) error
+
+	// Create will store webhook, setting its ID.
+	Create(ctx context.Context, webhook *Webhook) error
+
+	// Get will return the webhook with the given ID, or sql.ErrNoRows.
+	Get(ctx context.Context, id int64) (*Webhook, error)
+
+	// Matching will return the IDs of the webhooks event is delivered to.
+	// Events for a customer are only delivered to the customer's webhooks.
+	Matching(ctx context.Context, event events.Event) ([]int64, error)
+
+	// Enqueue will queue payload, describing event, for delivery to each of
+	// the webhooks. Events already queued for a webhook are ignored.
+	Enqueue(ctx context.Context, webhookIDs []int64, event events.Event, payload []byte) error
+
+	// Due will return up to limit pending deliveries due by now.
+	Due(ctx context.Context, now time.Time, limit int) ([]*Delivery, error)
+
+	// RecordAttempt will log attempt and move its delivery to status, to be
+	// attempted again at next when it is still pending.
+	RecordAttempt(ctx context.Context, attempt Attempt, status string, next time.Time) error
+
+	// Deliveries will return the delivery log of a webhook, newest first,
+	// optionally only those in status.
+	Deliveries(ctx context.Context, webhookID int64, status string, limit int) ([]*racing.WebhookDelivery, error)
+}
+
+type webhooksRepo struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+}
+
+// NewWebhooksRepo creates a new webhooks repository. Each query is bounded by
+// queryTimeout, unless it is zero.
+func NewWebhooksRepo(db *sql.DB, queryTimeout time.Duration) WebhooksRepo {
+	return &webhooksRepo{db: db, queryTimeout: queryTimeout}
+}
+
+func (r *webhooksRepo) Init(ctx context.Context) error {
+	for _, statement := range []string{
+		`CREATE TABLE IF NOT EXISTS webhooks (id INTEGER PRIMARY KEY AUTOINCREMENT, url TEXT NOT NULL, event_types TEXT NOT NULL, secret TEXT NOT NULL, owner TEXT NOT NULL, brand TEXT NOT NULL, created_at TEXT NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (id INTEGER PRIMARY KEY AUTOINCREMENT, webhook_id INTEGER NOT NULL REFERENCES webhooks (id), event_id INTEGER NOT NULL, event_type TEXT NOT NULL, payload TEXT NOT NULL, status TEXT NOT NULL, attempts INTEGER NOT NULL DEFAULT 0, created_at TEXT NOT NULL, next_attempt_at TEXT NOT NULL, UNIQUE (webhook_id, event_id))`,
+		`CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending'`,
+		`CREATE TABLE IF NOT EXISTS webhook_attempts (id INTEGER PRIMARY KEY AUTOINCREMENT, delivery_id INTEGER NOT NULL REFERENCES webhook_deliveries (id), attempt INTEGER NOT NULL, attempted_at TEXT NOT NULL, response_code INTEGER NOT NULL, error TEXT NOT NULL, duration_ms INTEGER NOT NULL)`,
+		`CREATE INDEX IF NOT EXISTS webhook_attempts_delivery_id ON webhook_attempts (delivery_id)`,
+	} {
+		if _, err := r.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *webhooksRepo) Create(ctx context.Context, webhook *Webhook) (err error) {
+	ctx, done := startQuery(ctx, webhooksCreate, r.queryTimeout)
+	defer func() { done(err) }()
+
+	res, err := r.db.ExecContext(
+		ctx,
+		getRaceQueries()[webhooksCreate],
+		webhook.URL,
+		strings.Join(webhook.EventTypes, ","),
+		webhook.Secret,
+		webhook.Owner,
+		webhook.Brand,
+		webhook.CreatedAt.UTC().Format(auditTimeLayout),
+	)
+	if err != nil {
+		return err
+	}
+
+	webhook.ID, err = res.LastInsertId()
+
+	return err
+}
+
+func (r *webhooksRepo) Get(ctx context.Context, id int64) (webhook *Webhook, err error) {
+	ctx, done := startQuery(ctx, webhooksGet, r.queryTimeout)
+	defer func() { done(err) }()
+
+	var (
+		w          Webhook
+		eventTypes string
+		createdAt  string
+	)
+
+	if err := r.db.QueryRowContext(ctx, getRaceQueries()[webhooksGet], id).Scan(&w.ID, &w.URL, &eventTypes, &w.Secret, &w.Owner, &w.Brand, &createdAt); err != nil {
+		return nil, err
+	}
+
+	if eventTypes != "" {
+		w.EventTypes = strings.Split(eventTypes, ",")
+	}
+
+	if w.CreatedAt, err = time.Parse(auditTimeLayout, createdAt); err != nil {
+		return nil, err
+	}
+
+	return &w, nil
+}
+
+func (r *webhooksRepo) Matching(ctx context.Context, event events.Event) (ids []int64, err error) {
+	ctx, done := startQuery(ctx, webhooksMatching, r.queryTimeout)
+	defer func() { done(err) }()
+
+	rows, err := r.db.QueryContext(ctx, getRaceQueries()[webhooksMatching], event.Brand, event.Customer, event.Type)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (r *webhooksRepo) Enqueue(ctx context.Context, webhookIDs []int64, event events.Event, payload []byte) (err error) {
+	ctx, done := startQuery(ctx, webhooksEnqueue, r.queryTimeout)
+	defer func() { done(err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statement, err := tx.PrepareContext(ctx, getRaceQueries()[webhooksEnqueue])
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	now := time.Now().UTC().Format(auditTimeLayout)
+
+	for _, id := range webhookIDs {
+		if _, err := statement.ExecContext(ctx, id, event.ID, event.Type, string(payload), DeliveryPending, now, now); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *webhooksRepo) Due(ctx context.Context, now time.Time, limit int) (due []*Delivery, err error) {
+	ctx, done := startQuery(ctx, webhooksDue, r.queryTimeout)
+	defer func() { done(err) }()
+
+	rows, err := r.db.QueryContext(ctx, getRaceQueries()[webhooksDue], now.UTC().Format(auditTimeLayout), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			d       Delivery
+			payload string
+		)
+
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.URL, &d.Secret, &d.EventID, &d.EventType, &payload, &d.Attempts); err != nil {
+			return nil, err
+		}
+
+		d.Payload = []byte(payload)
+		due = append(due, &d)
+	}
+
+	return due, rows.Err()
+}
+
+func (r *webhooksRepo) RecordAttempt(ctx context.Context, attempt Attempt, status string, next time.Time) (err error) {
+	ctx, done := startQuery(ctx, webhooksAttempt, r.queryTimeout)
+	defer func() { done(err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(
+		ctx,
+		getRaceQueries()[webhooksAttempt],
+		attempt.DeliveryID,
+		attempt.Attempt,
+		attempt.AttemptedAt.UTC().Format(auditTimeLayout),
+		attempt.ResponseCode,
+		attempt.Error,
+		attempt.Duration.Milliseconds(),
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		getRaceQueries()[webhooksReschedule],
+		status,
+		attempt.Attempt,
+		next.UTC().Format(auditTimeLayout),
+		attempt.DeliveryID,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *webhooksRepo) Deliveries(ctx context.Context, webhookID int64, status string, limit int) (deliveries []*racing.WebhookDelivery, err error) {
+	ctx, done := startQuery(ctx, webhooksDeliveries, r.queryTimeout)
+	defer func() { done(err) }()
+
+	query := getRaceQueries()[webhooksDeliveries]
+	args := []interface{}{webhookID}
+
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query+" ORDER BY id DESC LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]*racing.WebhookDelivery)
+
+	for rows.Next() {
+		var (
+			d                      racing.WebhookDelivery
+			createdAt, nextAttempt string
+		)
+
+		if err := rows.Scan(&d.Id, &d.WebhookId, &d.EventId, &d.EventType, &d.Status, &createdAt, &nextAttempt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if d.CreatedAt, err = parseTimestamp(createdAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if d.Status == DeliveryPending {
+			if d.NextAttemptAt, err = parseTimestamp(nextAttempt); err != nil {
+				rows.Close()
+				return nil, err
+			}
+		}
+
+		deliveries = append(deliveries, &d)
+		byID[d.Id] = &d
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(deliveries) == 0 {
+		return deliveries, nil
+	}
+
+	return deliveries, r.attachAttempts(ctx, byID)
+}
+
+// attachAttempts adds the attempts made at each delivery to it.
+func (r *webhooksRepo) attachAttempts(ctx context.Context, deliveries map[int64]*racing.WebhookDelivery) error {
+	args := make([]interface{}, 0, len(deliveries))
+	for id := range deliveries {
+		args = append(args, id)
+	}
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		getRaceQueries()[webhooksAttempts]+" WHERE delivery_id IN ("+strings.Repeat("?,", len(args)-1)+"?) ORDER BY delivery_id, attempt",
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			a           racing.WebhookAttempt
+			deliveryID  int64
+			attemptedAt string
+		)
+
+		if err := rows.Scan(&deliveryID, &a.Attempt, &attemptedAt, &a.ResponseCode, &a.Error, &a.DurationMs); err != nil {
+			return err
+		}
+
+		if a.AttemptedAt, err = parseTimestamp(attemptedAt); err != nil {
+			return err
+		}
+
+		d := deliveries[deliveryID]
+		d.Attempts = append(d.Attempts, &a)
+	}
+
+	return rows.Err()
+}
+
+// parseTimestamp parses a time stored in auditTimeLayout.
+func parseTimestamp(s string) (*timestamppb.Timestamp, error) {
+	t, err := time.Parse(auditTimeLayout, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return ptypes.TimestampProto(t)
+}
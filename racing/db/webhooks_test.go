@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/events"
+)
+
+// newTestWebhooksRepo returns a webhooks repository backed by a fresh
+// database.
+func newTestWebhooksRepo(t *testing.T) WebhooksRepo {
+	t.Helper()
+
+	racingDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "racing.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { racingDB.Close() })
+
+	repo := NewWebhooksRepo(racingDB, 0)
+	if err := repo.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return repo
+}
+
+func TestWebhooksMatching(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestWebhooksRepo(t)
+
+	ids := make(map[string]int64)
+
+	for _, w := range []struct {
+		name       string
+		owner      string
+		brand      string
+		eventTypes []string
+	}{
+		{"shared", "partner", "", nil},
+		{"neds", "partner", "neds", nil},
+		{"ladbrokes", "partner", "ladbrokes", nil},
+		{"neds updates", "partner", "neds", []string{events.RaceUpdated}},
+		{"neds changes", "partner", "neds", []string{events.RaceCreated, events.RaceDeleted}},
+		{"neds reminders", "customer", "neds", []string{events.RaceReminder}},
+	} {
+		webhook := &Webhook{URL: "https://example.com/" + w.name, EventTypes: w.eventTypes, Secret: "secret", Owner: w.owner, Brand: w.brand, CreatedAt: time.Now()}
+		if err := repo.Create(ctx, webhook); err != nil {
+			t.Fatal(err)
+		}
+
+		ids[w.name] = webhook.ID
+	}
+
+	for _, tc := range []struct {
+		name  string
+		event events.Event
+		want  []string
+	}{
+		{
+			name:  "shared races go to every brand",
+			event: events.Event{Type: events.RaceCreated},
+			want:  []string{"shared", "neds", "ladbrokes", "neds changes"},
+		},
+		{
+			name:  "branded races only go to the brand",
+			event: events.Event{Type: events.RaceCreated, Brand: "neds"},
+			want:  []string{"neds", "neds changes"},
+		},
+		{
+			name:  "event types are matched exactly",
+			event: events.Event{Type: events.RaceUpdated, Brand: "neds"},
+			want:  []string{"neds", "neds updates"},
+		},
+		{
+			name:  "any listed event type matches",
+			event: events.Event{Type: events.RaceDeleted, Brand: "neds"},
+			want:  []string{"neds", "neds changes"},
+		},
+		{
+			name:  "other brands receive nothing",
+			event: events.Event{Type: events.RaceUpdated, Brand: "betr"},
+		},
+		{
+			name:  "notifications only go to the customer",
+			event: events.Event{Type: events.RaceReminder, Brand: "neds", Customer: "customer"},
+			want:  []string{"neds reminders"},
+		},
+		{
+			name:  "notifications for other customers go nowhere",
+			event: events.Event{Type: events.RaceReminder, Brand: "neds", Customer: "someone else"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := repo.Matching(ctx, tc.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want []int64
+			for _, name := range tc.want {
+				want = append(want, ids[name])
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got webhooks %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// Bus is an in-process publisher, fanning events out to its subscribers.
+// Publishing blocks until every matching subscriber has room for the event,
+// so slow subscribers apply back-pressure rather than miss events.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// NewBus returns a bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives the events published to a bus after it was created.
+type Subscription struct {
+	// C delivers the subscribed events.
+	C <-chan Event
+
+	c     chan Event
+	types map[string]bool
+	done  chan struct{}
+	once  sync.Once
+	bus   *Bus
+}
+
+// Subscribe returns a subscription to events of the given types, or to every
+// event when none are given, buffering up to buffer events.
+func (b *Bus) Subscribe(buffer int, types ...string) *Subscription {
+	c := make(chan Event, buffer)
+
+	s := &Subscription{C: c, c: c, done: make(chan struct{}), bus: b}
+	if len(types) > 0 {
+		s.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+
+	return s
+}
+
+// Close stops the subscription. Events may no longer be received from C.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+
+		close(s.done)
+	})
+}
+
+func (b *Bus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for s := range b.subs {
+		if s.types == nil || s.types[event.Type] {
+			subs = append(subs, s)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, s := range subs {
+		select {
+		case s.c <- event:
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
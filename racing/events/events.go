@@ -0,0 +1,109 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Types of race events.
+const (
+	RaceCreated = "race.created"
+	RaceUpdated = "race.updated"
+	RaceDeleted = "race.deleted"
+	// RaceReminder reminds a customer that a race is about to start.
+	RaceReminder = "race.reminder"
+	// EntityEntered tells a customer that a runner, jockey or trainer they
+	// follow was entered in a race.
+	EntityEntered = "entity.entered"
+)
+
+// Types lists every type of event.
+var Types = []string{RaceCreated, RaceUpdated, RaceDeleted, RaceReminder, EntityEntered}
+
+// Known reports whether t is a type of event.
+func Known(t string) bool {
+	for _, known := range Types {
+		if t == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Event describes a change to a race, or a notification about a race for a
+// customer. Events are delivered at least once, so
+// consumers should ignore IDs they have already seen.
+type Event struct {
+	// ID increases with every event, in the order the changes were made.
+	ID         int64     `json:"id"`
+	Type       string    `json:"type"`
+	RaceID     int64     `json:"race_id"`
+	Brand      string    `json:"brand,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+	RequestID  string    `json:"request_id,omitempty"`
+	// Race is the race as stored after the change, or before it for
+	// deletions, encoded as protobuf JSON.
+	Race json.RawMessage `json:"race"`
+	// Previous is the race as stored before an update.
+	Previous json.RawMessage `json:"previous,omitempty"`
+	// Customer is the customer a notification is for.
+	Customer string `json:"customer,omitempty"`
+	// Data holds the details specific to the type of event, such as
+	// ReminderData or EntityEnteredData.
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// ReminderData details a race.reminder event.
+type ReminderData struct {
+	// OffsetSeconds is how long before the race's advertised start the
+	// reminder is for.
+	OffsetSeconds int64 `json:"offset_seconds"`
+}
+
+// EntityEnteredData details an entity.entered event.
+type EntityEnteredData struct {
+	EntityType string `json:"entity_type"`
+	EntityID   string `json:"entity_id"`
+	// RunnerNumber is the number of the runner the entity was entered with.
+	RunnerNumber int64 `json:"runner_number"`
+}
+
+// DecodeRace decodes the race the event describes.
+func (e Event) DecodeRace() (*racing.Race, error) {
+	var race racing.Race
+	if err := protojson.Unmarshal(e.Race, &race); err != nil {
+		return nil, err
+	}
+
+	return &race, nil
+}
+
+// Publisher publishes events to consumers.
+type Publisher interface {
+	// Publish delivers event, returning once it has been accepted.
+	Publish(ctx context.Context, event Event) error
+}
+
+// multi publishes to several publishers in turn.
+type multi []Publisher
+
+// Multi returns a publisher delivering each event to every publisher, in
+// order, stopping at the first failure.
+func Multi(publishers ...Publisher) Publisher {
+	return multi(publishers)
+}
+
+func (m multi) Publish(ctx context.Context, event Event) error {
+	for _, p := range m {
+		if err := p.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
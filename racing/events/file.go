@@ -0,0 +1,135 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// FileLog is a publisher appending events to a file, one JSON object per
+// line. It is append-only and skips events it already holds, so that events
+// redelivered after a restart are written once. Notifications for customers
+// are private to them, so they are left out.
+type FileLog struct {
+	mu   sync.Mutex
+	f    *os.File
+	last int64
+}
+
+// OpenFileLog opens the event log at path, creating it if needed. A line
+// left incomplete by a crash is discarded.
+func OpenFileLog(path string) (*FileLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &FileLog{f: f}
+
+	size, err := l.recover()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("event log %s: %w", path, err)
+	}
+
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// recover finds the last event in the log, returning the size of its
+// complete lines.
+func (l *FileLog) recover() (int64, error) {
+	r := bufio.NewReader(l.f)
+
+	var size int64
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return 0, fmt.Errorf("line at offset %d: %w", size, err)
+		}
+
+		l.last = event.ID
+		size += int64(len(line))
+	}
+}
+
+func (l *FileLog) Publish(_ context.Context, event Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if event.ID <= l.last || event.Customer != "" {
+		return nil
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+
+	l.last = event.ID
+
+	return nil
+}
+
+// Close closes the log.
+func (l *FileLog) Close() error {
+	return l.f.Close()
+}
+
+// ReadFileLog calls fn with each event in the log at path, in order.
+func ReadFileLog(path string, fn func(Event) error) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		// Skip the trailing empty split and any incomplete final line.
+		if len(line) == 0 || line[len(line)-1] != '\n' {
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return err
+		}
+
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package events
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// logged returns the IDs of the events in the log at path.
+func logged(t *testing.T, path string) []int64 {
+	t.Helper()
+
+	var ids []int64
+
+	if err := ReadFileLog(path, func(event Event) error {
+		ids = append(ids, event.ID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return ids
+}
+
+func publish(t *testing.T, l *FileLog, events ...Event) {
+	t.Helper()
+
+	for _, event := range events {
+		if err := l.Publish(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileLogSkipsDuplicates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+
+	l, err := OpenFileLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, l,
+		Event{ID: 1, Type: RaceCreated},
+		Event{ID: 2, Type: RaceUpdated},
+		Event{ID: 2, Type: RaceUpdated},
+		Event{ID: 1, Type: RaceCreated},
+		Event{ID: 3, Type: RaceReminder, Customer: "alice"},
+	)
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Events redelivered after a restart are skipped too.
+	if l, err = OpenFileLog(path); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	publish(t, l, Event{ID: 2, Type: RaceUpdated}, Event{ID: 4, Type: RaceDeleted})
+
+	if got, want := logged(t, path), []int64{1, 2, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+}
+
+func TestOpenFileLogTruncatesIncompleteLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+
+	l, err := OpenFileLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, l, Event{ID: 1, Type: RaceCreated}, Event{ID: 2, Type: RaceUpdated})
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash part way through writing event 3.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.WriteString(`{"id":3,"type":"race.upd`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if l, err = OpenFileLog(path); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Event 3 was never acknowledged, so it is published again in full.
+	publish(t, l, Event{ID: 3, Type: RaceUpdated})
+
+	if got, want := logged(t, path), []int64{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data[len(data)-1] != '\n' {
+		t.Errorf("got log ending %q, want a complete line", data[len(data)-20:])
+	}
+}
@@ -0,0 +1,84 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Checker reports the serving status of the racing service over the standard
+// grpc.health.v1 protocol.
+type Checker struct {
+	*health.Server
+
+	db       *sql.DB
+	services []string
+	ready    int32
+}
+
+// NewChecker creates a new health checker for the given services. Every
+// service starts as NOT_SERVING until MarkReady is called.
+func NewChecker(db *sql.DB, services ...string) *Checker {
+	c := &Checker{
+		Server: health.NewServer(),
+		db:     db,
+		// The empty service name represents the overall health of the server.
+		services: append([]string{""}, services...),
+	}
+
+	c.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+
+	return c
+}
+
+// MarkReady flags the service as initialised, so that it is reported as
+// SERVING for as long as the database remains reachable.
+func (c *Checker) MarkReady(ctx context.Context) {
+	atomic.StoreInt32(&c.ready, 1)
+	c.check(ctx)
+}
+
+// Monitor pings the database every interval and updates the serving status
+// accordingly, until ctx is cancelled.
+func (c *Checker) Monitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.check(ctx)
+		}
+	}
+}
+
+func (c *Checker) check(ctx context.Context) {
+	if atomic.LoadInt32(&c.ready) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	if err := c.db.PingContext(ctx); err != nil {
+		zap.L().Warn("database health check failed", zap.Error(err))
+		c.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+
+		return
+	}
+
+	c.setStatus(healthpb.HealthCheckResponse_SERVING)
+}
+
+func (c *Checker) setStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	for _, service := range c.services {
+		c.SetServingStatus(service, status)
+	}
+}
@@ -0,0 +1,49 @@
+package health
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor rejects calls to the checked services with
+// UNAVAILABLE until MarkReady is called, so that nothing reaches the database
+// before it has been initialised. The health service itself is unaffected.
+func (c *Checker) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := c.admit(info.FullMethod); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the streaming equivalent of UnaryServerInterceptor.
+func (c *Checker) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := c.admit(info.FullMethod); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+func (c *Checker) admit(method string) error {
+	if atomic.LoadInt32(&c.ready) == 1 {
+		return nil
+	}
+
+	for _, service := range c.services {
+		if service != "" && strings.HasPrefix(method, "/"+service+"/") {
+			return status.Error(codes.Unavailable, "service is starting up")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"git.neds.sh/matty/entain/common/claims"
+	"git.neds.sh/matty/entain/racing/audit"
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/importer"
+)
+
+// runImport implements the import command, which imports race card files
+// straight into the races database.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dbPath := fs.String("db", "./db/racing.db", "Races database to import into")
+	format := fs.String("format", "", "Race card format, csv or json (taken from each file's extension when empty)")
+	dryRun := fs.Bool("dry-run", false, "Validate and report the outcome without writing anything")
+	brand := fs.String("brand", "", "Brand owning the imported races (shared by every brand when empty)")
+	actor := fs.String("actor", "cli:"+os.Getenv("USER"), "Who the imported changes are attributed to in the audit log")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import [flags] file...\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no race card files given")
+	}
+
+	if *brand != "" {
+		if err := claims.ValidateBrand(*brand); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	ctx = audit.WithActor(ctx, *actor)
+
+	racingDB, err := sql.Open("sqlite3", *dbPath)
+	if err != nil {
+		return err
+	}
+	defer racingDB.Close()
+
+	// A zero seed configuration only creates the schema.
+	racesRepo := db.NewRacesRepo(racingDB, 0, db.SeedConfig{})
+	if err := racesRepo.Init(ctx); err != nil {
+		return err
+	}
+
+	rejected := 0
+
+	for _, file := range fs.Args() {
+		result, err := importFile(ctx, racesRepo, file, *format, *brand, *dryRun)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+
+		printImportResult(os.Stdout, file, result)
+		rejected += result.Rejected
+	}
+
+	if rejected > 0 {
+		return fmt.Errorf("%d rows rejected", rejected)
+	}
+
+	return nil
+}
+
+// importFile imports a single race card, as its own import.
+func importFile(ctx context.Context, racesRepo db.RacesRepo, file, format, brand string, dryRun bool) (*importer.Result, error) {
+	var (
+		f   importer.Format
+		err error
+	)
+
+	if format != "" {
+		f, err = importer.ParseFormat(format)
+	} else {
+		f, err = importer.FormatOf(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	imp := importer.New(racesRepo, brand, dryRun)
+	defer imp.Abort()
+
+	if err := importer.Read(r, f, func(row importer.Row) error {
+		return imp.Add(ctx, row)
+	}); err != nil {
+		return nil, err
+	}
+
+	return imp.Close(ctx)
+}
+
+func printImportResult(w io.Writer, file string, result *importer.Result) {
+	for _, e := range result.Errors {
+		fmt.Fprintf(w, "%s: %s\n", file, e)
+	}
+
+	verb := "imported"
+	if result.DryRun {
+		verb = "would import"
+	}
+
+	fmt.Fprintf(w, "%s: %s %d of %d races (%d created, %d updated, %d rejected)\n",
+		file, verb, result.Created+result.Updated, result.Received, result.Created, result.Updated, result.Rejected)
+}
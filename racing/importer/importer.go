@@ -0,0 +1,275 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+
+	"git.neds.sh/matty/entain/racing/apperr"
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+)
+
+const (
+	// batchSize bounds the number of races held in memory before they are
+	// written.
+	batchSize = 500
+
+	// maxNameLength bounds the length of race names, in characters.
+	maxNameLength = 255
+)
+
+// Row is a race read from a race card, along with any problems found while
+// parsing it.
+type Row struct {
+	Race       *racing.Race
+	Violations []apperr.FieldViolation
+}
+
+// RowError describes why a row was rejected.
+type RowError struct {
+	// Row is the position of the row in the import, starting at 1.
+	Row         int
+	Field       string
+	Description string
+}
+
+func (e RowError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("row %d: %s", e.Row, e.Description)
+	}
+
+	return fmt.Sprintf("row %d: %s: %s", e.Row, e.Field, e.Description)
+}
+
+// Result summarises an import.
+type Result struct {
+	Received int
+	Created  int
+	Updated  int
+	Rejected int
+	Errors   []RowError
+	DryRun   bool
+}
+
+// Importer validates rows and upserts the valid ones into a races
+// repository, in a single transaction committed by Close. Invalid rows are
+// skipped and reported in the result.
+type Importer struct {
+	repo   db.RacesRepo
+	brand  string
+	imp    db.RaceImport
+	seen   map[int64]int
+	batch  []*racing.Race
+	result Result
+}
+
+// New returns an Importer writing races owned by brand to repo. Races owned
+// by other brands are rejected. A dry run validates rows and reports what
+// would be written, without committing anything.
+func New(repo db.RacesRepo, brand string, dryRun bool) *Importer {
+	return &Importer{
+		repo:   repo,
+		brand:  brand,
+		seen:   make(map[int64]int),
+		result: Result{DryRun: dryRun},
+	}
+}
+
+// Add validates row and queues it to be written. An error is only returned
+// when writing a batch fails, after which the import must be aborted.
+func (i *Importer) Add(ctx context.Context, row Row) error {
+	i.result.Received++
+	n := i.result.Received
+
+	// Fields that could not be parsed have already been reported, and rows
+	// that could not be parsed at all have nothing left to validate.
+	violations := append([]apperr.FieldViolation(nil), row.Violations...)
+	if row.Race != nil || len(violations) == 0 {
+		for _, v := range validate(row.Race) {
+			if !hasField(row.Violations, v.Field) {
+				violations = append(violations, v)
+			}
+		}
+	}
+
+	if len(violations) == 0 {
+		if first, ok := i.seen[row.Race.Id]; ok {
+			violations = append(violations, apperr.FieldViolation{
+				Field:       "id",
+				Description: fmt.Sprintf("duplicates row %d", first),
+			})
+		} else {
+			i.seen[row.Race.Id] = n
+		}
+	}
+
+	if len(violations) > 0 {
+		i.result.Rejected++
+
+		for _, v := range violations {
+			i.result.Errors = append(i.result.Errors, RowError{Row: n, Field: v.Field, Description: v.Description})
+		}
+
+		return nil
+	}
+
+	i.batch = append(i.batch, row.Race)
+	if len(i.batch) >= batchSize {
+		return i.flush(ctx)
+	}
+
+	return nil
+}
+
+// Close writes any queued races, commits the import unless it is a dry run,
+// and returns the result of the import.
+func (i *Importer) Close(ctx context.Context) (*Result, error) {
+	if err := i.flush(ctx); err != nil {
+		return nil, err
+	}
+
+	if i.imp != nil {
+		commit := i.imp.Commit
+		if i.result.DryRun {
+			commit = i.imp.Rollback
+		}
+
+		if err := commit(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Rows rejected by the repository are reported after those rejected
+	// by validation, so restore the order of the import.
+	sort.SliceStable(i.result.Errors, func(a, b int) bool {
+		return i.result.Errors[a].Row < i.result.Errors[b].Row
+	})
+
+	return &i.result, nil
+}
+
+// Abort discards every race written, unless the import was closed. The
+// import may not be used afterwards.
+func (i *Importer) Abort() {
+	if i.imp != nil {
+		i.imp.Rollback()
+	}
+}
+
+func (i *Importer) flush(ctx context.Context) error {
+	if len(i.batch) == 0 {
+		return nil
+	}
+
+	if i.imp == nil {
+		imp, err := i.repo.BeginImport(ctx, i.brand)
+		if err != nil {
+			return err
+		}
+
+		i.imp = imp
+	}
+
+	res, err := i.imp.Write(ctx, i.batch)
+	if err != nil {
+		return err
+	}
+
+	i.result.Created += res.Created
+	i.result.Updated += res.Updated
+	i.result.Rejected += len(res.Foreign)
+
+	for _, id := range res.Foreign {
+		i.result.Errors = append(i.result.Errors, RowError{Row: i.seen[id], Field: "id", Description: "belongs to another brand"})
+	}
+
+	i.batch = nil
+
+	return nil
+}
+
+func hasField(violations []apperr.FieldViolation, field string) bool {
+	for _, v := range violations {
+		if v.Field == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validate checks race against the rules for stored races.
+func validate(race *racing.Race) []apperr.FieldViolation {
+	if race == nil {
+		return []apperr.FieldViolation{{Description: "is empty"}}
+	}
+
+	var v []apperr.FieldViolation
+
+	add := func(field, description string) {
+		v = append(v, apperr.FieldViolation{Field: field, Description: description})
+	}
+
+	if race.Id <= 0 {
+		add("id", "must be a positive id")
+	}
+
+	if race.MeetingId <= 0 {
+		add("meeting_id", "must be a positive id")
+	}
+
+	switch {
+	case race.Name == "":
+		add("name", "is required")
+	case utf8.RuneCountInString(race.Name) > maxNameLength:
+		add("name", fmt.Sprintf("must not be longer than %d characters", maxNameLength))
+	}
+
+	if race.Number <= 0 {
+		add("number", "must be positive")
+	}
+
+	if race.AdvertisedStartTime == nil {
+		add("advertised_start_time", "is required")
+	} else if err := race.AdvertisedStartTime.CheckValid(); err != nil {
+		add("advertised_start_time", "is not a valid time")
+	}
+
+	numbers := make(map[int64]bool)
+
+	for i, runner := range race.Runners {
+		path := fmt.Sprintf("runners[%d]", i)
+
+		switch {
+		case runner.Number <= 0:
+			add(path+".number", "must be positive")
+		case numbers[runner.Number]:
+			add(path+".number", "duplicates another runner")
+		}
+		numbers[runner.Number] = true
+
+		if err := db.ValidateEntityID(runner.RunnerId); err != nil {
+			add(path+".runner_id", err.Error())
+		}
+
+		for _, id := range []struct {
+			field string
+			value string
+		}{
+			{"jockey_id", runner.JockeyId},
+			{"trainer_id", runner.TrainerId},
+		} {
+			if id.value == "" {
+				continue
+			}
+
+			if err := db.ValidateEntityID(id.value); err != nil {
+				add(path+"."+id.field, err.Error())
+			}
+		}
+	}
+
+	return v
+}
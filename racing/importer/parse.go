@@ -0,0 +1,247 @@
+package importer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/apperr"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Format is a race card file format.
+type Format string
+
+const (
+	// CSV race cards have a header row naming the race fields, e.g.
+	// "id,meeting_id,name,number,visible,advertised_start_time", with times
+	// in RFC 3339 format. They cannot list runners.
+	CSV Format = "csv"
+	// JSON race cards hold an array of races, or an object with a "races"
+	// array, in the protobuf JSON mapping, and may list each race's runners.
+	JSON Format = "json"
+)
+
+// ParseFormat returns the format with the given name.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case CSV, JSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown race card format %q, expected csv or json", name)
+	}
+}
+
+// FormatOf returns the format of the named file, based on its extension.
+func FormatOf(file string) (Format, error) {
+	return ParseFormat(strings.TrimPrefix(filepath.Ext(file), "."))
+}
+
+// Read parses the race card in r, calling fn with each row in order. Rows
+// that cannot be parsed are still passed to fn, with their violations, while
+// errors reading the card as a whole abort it.
+func Read(r io.Reader, format Format, fn func(Row) error) error {
+	switch format {
+	case CSV:
+		return readCSV(r, fn)
+	case JSON:
+		return readJSON(r, fn)
+	default:
+		return fmt.Errorf("unknown race card format %q", format)
+	}
+}
+
+// csvColumns maps CSV header names to the race field they set.
+var csvColumns = map[string]func(race *racing.Race, value string) error{
+	"id": func(race *racing.Race, value string) (err error) {
+		race.Id, err = parseInt(value)
+		return err
+	},
+	"meeting_id": func(race *racing.Race, value string) (err error) {
+		race.MeetingId, err = parseInt(value)
+		return err
+	},
+	"name": func(race *racing.Race, value string) error {
+		race.Name = value
+		return nil
+	},
+	"number": func(race *racing.Race, value string) (err error) {
+		race.Number, err = parseInt(value)
+		return err
+	},
+	"visible": func(race *racing.Race, value string) (err error) {
+		if value == "" {
+			return nil
+		}
+
+		race.Visible, err = strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+
+		return nil
+	},
+	"advertised_start_time": func(race *racing.Race, value string) error {
+		if value == "" {
+			return nil
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return errors.New("must be an RFC 3339 time")
+		}
+
+		race.AdvertisedStartTime = timestamppb.New(t)
+
+		return nil
+	},
+}
+
+func readCSV(r io.Reader, fn func(Row) error) error {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading csv header: %w", err)
+	}
+
+	columns := make([]string, len(header))
+	seen := make(map[string]bool)
+
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := csvColumns[name]; !ok {
+			return fmt.Errorf("csv header: unknown column %q", name)
+		}
+
+		if seen[name] {
+			return fmt.Errorf("csv header: duplicate column %q", name)
+		}
+
+		columns[i] = name
+		seen[name] = true
+	}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+
+		var row Row
+
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr) && parseErr.Err == csv.ErrFieldCount:
+			row.Violations = append(row.Violations, apperr.FieldViolation{
+				Description: fmt.Sprintf("has %d fields, expected %d", len(record), len(columns)),
+			})
+		case err != nil:
+			return fmt.Errorf("reading csv: %w", err)
+		default:
+			row.Race = &racing.Race{}
+
+			for i, value := range record {
+				if err := csvColumns[columns[i]](row.Race, strings.TrimSpace(value)); err != nil {
+					row.Violations = append(row.Violations, apperr.FieldViolation{Field: columns[i], Description: err.Error()})
+				}
+			}
+		}
+
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+func parseInt(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errors.New("must be an integer")
+	}
+
+	return n, nil
+}
+
+func readJSON(r io.Reader, fn func(Row) error) error {
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("reading json: %w", err)
+	}
+
+	switch tok {
+	case json.Delim('['):
+		return readJSONRaces(dec, fn)
+	case json.Delim('{'):
+		found := false
+
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return fmt.Errorf("reading json: %w", err)
+			}
+
+			if key != "races" || found {
+				return fmt.Errorf("reading json: unexpected key %q, expected a single races array", key)
+			}
+
+			if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+				return errors.New("reading json: races must be an array")
+			}
+
+			if err := readJSONRaces(dec, fn); err != nil {
+				return err
+			}
+
+			found = true
+		}
+
+		return nil
+	default:
+		return errors.New("reading json: expected an array of races or an object with a races array")
+	}
+}
+
+// readJSONRaces reads the elements of a JSON array of races, up to and
+// including its closing bracket.
+func readJSONRaces(dec *json.Decoder, fn func(Row) error) error {
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return fmt.Errorf("reading json: %w", err)
+		}
+
+		row := Row{Race: &racing.Race{}}
+		if err := protojson.Unmarshal(raw, row.Race); err != nil {
+			row = Row{Violations: []apperr.FieldViolation{{Description: err.Error()}}}
+		}
+
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("reading json: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package jurisdiction
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Effect is what a rule does to races in its region.
+type Effect string
+
+const (
+	// Allow restricts races to the regions they are allowed in.
+	Allow Effect = "allow"
+	// Block excludes races from a region.
+	Block Effect = "block"
+)
+
+// ParseEffect parses a rule effect.
+func ParseEffect(effect string) (Effect, error) {
+	switch e := Effect(strings.ToLower(effect)); e {
+	case Allow, Block:
+		return e, nil
+	default:
+		return "", fmt.Errorf("invalid effect %q, expected allow or block", effect)
+	}
+}
+
+// Rule allows or blocks a meeting, or a single race, in a region. Exactly
+// one of MeetingID and RaceID is set.
+type Rule struct {
+	MeetingID int64
+	RaceID    int64
+	Region    string
+	Effect    Effect
+}
+
+// covers reports whether the rule's region contains jurisdiction, so that a
+// rule for "AU" covers callers in "AU-NSW".
+func (r Rule) covers(jurisdiction string) bool {
+	return jurisdiction == r.Region || strings.HasPrefix(jurisdiction, r.Region+"-")
+}
+
+// Policy decides which races may be shown in a jurisdiction.
+type Policy struct {
+	races    map[int64][]Rule
+	meetings map[int64][]Rule
+}
+
+// NewPolicy builds a policy from rules.
+func NewPolicy(rules []Rule) *Policy {
+	p := &Policy{races: make(map[int64][]Rule), meetings: make(map[int64][]Rule)}
+
+	for _, rule := range rules {
+		if rule.RaceID != 0 {
+			p.races[rule.RaceID] = append(p.races[rule.RaceID], rule)
+		} else {
+			p.meetings[rule.MeetingID] = append(p.meetings[rule.MeetingID], rule)
+		}
+	}
+
+	return p
+}
+
+// Check returns why the race is excluded in jurisdiction, or "" when it may
+// be shown. Rules for the race replace those of its meeting. A block rule
+// covering the jurisdiction always excludes the race, as does having allow
+// rules none of which cover it. Races with rules are excluded for callers of
+// unknown jurisdiction.
+func (p *Policy) Check(raceID, meetingID int64, jurisdiction string) string {
+	scope := "race"
+	rules := p.races[raceID]
+	if len(rules) == 0 {
+		scope = "meeting"
+		rules = p.meetings[meetingID]
+	}
+
+	if len(rules) == 0 {
+		return ""
+	}
+
+	if jurisdiction == "" {
+		return fmt.Sprintf("%s is restricted by jurisdiction and the caller's jurisdiction is unknown", scope)
+	}
+
+	var (
+		allows  []string
+		allowed bool
+	)
+
+	for _, rule := range rules {
+		switch rule.Effect {
+		case Block:
+			if rule.covers(jurisdiction) {
+				return fmt.Sprintf("%s is blocked in %s", scope, rule.Region)
+			}
+		case Allow:
+			allowed = allowed || rule.covers(jurisdiction)
+			allows = append(allows, rule.Region)
+		}
+	}
+
+	if len(allows) > 0 && !allowed {
+		return fmt.Sprintf("%s is only allowed in %s", scope, strings.Join(allows, ", "))
+	}
+
+	return ""
+}
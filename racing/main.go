@@ -1,31 +1,128 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	_ "time/tzdata"
 
+	"git.neds.sh/matty/entain/common/logging"
+	"git.neds.sh/matty/entain/common/tlsconfig"
+	"git.neds.sh/matty/entain/common/tracing"
+	"git.neds.sh/matty/entain/racing/apperr"
+	"git.neds.sh/matty/entain/racing/caller"
 	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/events"
+	"git.neds.sh/matty/entain/racing/health"
+	"git.neds.sh/matty/entain/racing/outbox"
 	"git.neds.sh/matty/entain/racing/proto/racing"
+	"git.neds.sh/matty/entain/racing/reminders"
 	"git.neds.sh/matty/entain/racing/service"
+	"git.neds.sh/matty/entain/racing/validation"
+	"git.neds.sh/matty/entain/racing/webhooks"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var (
-	grpcEndpoint = flag.String("grpc-endpoint", "localhost:9000", "gRPC server endpoint")
+	grpcEndpoint        = flag.String("grpc-endpoint", "localhost:9000", "gRPC server endpoint, which should only be reachable by the gateway unless mutual TLS is enabled")
+	healthCheckInterval = flag.Duration("health-check-interval", 10*time.Second, "Interval between database health checks")
+	metricsEndpoint     = flag.String("metrics-endpoint", "localhost:9100", "Prometheus metrics endpoint")
+	traceOutput         = flag.String("trace-output", "", "File to export trace spans to, or - for stdout (disabled when empty)")
+	logLevel            = flag.String("log-level", "info", "Minimum log level (debug, info, warn, error)")
+	queryTimeout        = flag.Duration("query-timeout", 5*time.Second, "Maximum duration of a single database query (0 disables)")
+	cacheTTL            = flag.Duration("cache-ttl", 5*time.Second, "How long race list results and jurisdiction rules are cached for (0 disables)")
+	cacheSize           = flag.Int("cache-size", 1000, "Maximum number of race list results held in the cache")
+	tlsCert             = flag.String("tls-cert", "", "PEM certificate served to gRPC clients (plaintext when empty)")
+	tlsKey              = flag.String("tls-key", "", "PEM private key for -tls-cert")
+	tlsClientCA         = flag.String("tls-client-ca", "", "PEM CA bundle that client certificates must chain to, enabling mutual TLS and trusting authenticated clients to vouch for callers")
+	trustLoopback       = flag.Bool("trust-loopback-callers", false, "Trust loopback clients without mutual TLS to vouch for callers, e.g. a gateway on the same host; any local process may then act as an administrator")
+	tlsReloadInterval   = flag.Duration("tls-reload-interval", time.Minute, "How often TLS files are checked for changes (0 disables)")
+
+	seed                = flag.Int64("seed", 0, "Random seed for generated races, for reproducible data (random when 0)")
+	seedMeetings        = flag.Int("seed-meetings", 10, "Number of meetings to generate races for")
+	seedRacesPerMeeting = flag.Int("seed-races-per-meeting", 10, "Number of races generated per meeting")
+	seedStart           = flag.String("seed-start", "", "RFC 3339 time generated races are spread around (now when empty)")
+	seedSpreadBefore    = flag.Duration("seed-spread-before", 24*time.Hour, "How long before -seed-start generated races may start")
+	seedSpreadAfter     = flag.Duration("seed-spread-after", 48*time.Hour, "How long after -seed-start generated races may start")
+	seedFixtures        = flag.String("seed-fixtures", "", "Comma separated JSON or YAML fixture files to seed races from instead of generating them")
+	seedReset           = flag.Bool("seed-reset", false, "Remove existing races before seeding")
+
+	outboxInterval  = flag.Duration("outbox-interval", time.Second, "How often the outbox is polled for race events to publish")
+	outboxBatchSize = flag.Int("outbox-batch-size", 100, "Maximum number of race events read from the outbox at once")
+	eventLog        = flag.String("event-log", "", "File race events are appended to, one JSON object per line (disabled when empty)")
+
+	webhookMaxAttempts    = flag.Int("webhook-max-attempts", 8, "Number of attempts made to deliver an event to a webhook before it is dead lettered")
+	webhookInitialBackoff = flag.Duration("webhook-initial-backoff", 10*time.Second, "Delay before a failed webhook delivery is first retried, doubling with each attempt")
+	webhookMaxBackoff     = flag.Duration("webhook-max-backoff", time.Hour, "Maximum delay between webhook delivery attempts")
+	webhookTimeout        = flag.Duration("webhook-timeout", 10*time.Second, "Maximum duration of a single webhook delivery attempt")
+	webhookPollInterval   = flag.Duration("webhook-poll-interval", time.Second, "How often due webhook deliveries are looked for")
+	webhookAllowInsecure  = flag.Bool("webhook-allow-insecure", false, "Allow webhooks to be registered with plain HTTP URLs")
+	webhookAllowPrivate   = flag.Bool("webhook-allow-private", false, "Allow webhooks to deliver to loopback, private and link-local addresses, e.g. for local testing")
+
+	reminderOffsets  = flag.String("reminder-offsets", "10m,2m", "Comma separated durations before a race's advertised start that subscribers are reminded at")
+	reminderInterval = flag.Duration("reminder-interval", 5*time.Second, "How often due race reminders are looked for")
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImport(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "import failed: %s\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	flag.Parse()
 
-	if err := run(); err != nil {
-		log.Fatalf("failed running grpc server: %s\n", err)
+	logger, err := logging.New(*logLevel)
+	if err != nil {
+		log.Fatalf("failed creating logger: %s\n", err)
+	}
+	defer logger.Sync()
+
+	zap.ReplaceGlobals(logger)
+
+	caller.TrustLoopback = *trustLoopback
+	if caller.TrustLoopback {
+		logger.Warn("trusting loopback clients to vouch for callers without mutual TLS")
+	}
+
+	if err := run(logger); err != nil {
+		logger.Fatal("failed running grpc server", zap.Error(err))
 	}
 }
 
-func run() error {
-	conn, err := net.Listen("tcp", ":9000")
+func run(logger *zap.Logger) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	shutdownTracing, err := tracing.Init("racing", *traceOutput)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
+	conn, err := net.Listen("tcp", *grpcEndpoint)
 	if err != nil {
 		return err
 	}
@@ -35,25 +132,282 @@ func run() error {
 		return err
 	}
 
-	racesRepo := db.NewRacesRepo(racingDB)
-	if err := racesRepo.Init(); err != nil {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(racingDB, "racing"))
+
+	seedConfig, err := newSeedConfig()
+	if err != nil {
 		return err
 	}
 
-	grpcServer := grpc.NewServer()
+	// Race events are relayed from the outbox to in-process subscribers, and
+	// to the event log when enabled.
+	bus := events.NewBus()
+
+	racesRepo := db.NewCachedRacesRepo(
+		db.NewRacesRepo(racingDB, *queryTimeout, seedConfig),
+		*cacheTTL,
+		*cacheSize,
+	)
+	rulesRepo := db.NewCachedRulesRepo(db.NewRulesRepo(racingDB, *queryTimeout), *cacheTTL)
+
+	webhooksRepo := db.NewWebhooksRepo(racingDB, *queryTimeout)
+	remindersRepo := db.NewRemindersRepo(racingDB, *queryTimeout)
+	blackbookRepo := db.NewBlackbookRepo(racingDB, *queryTimeout)
+
+	scheduler, err := newScheduler(remindersRepo, rulesRepo)
+	if err != nil {
+		return err
+	}
+
+	deliverer, err := newDeliverer(webhooksRepo)
+	if err != nil {
+		return err
+	}
+
+	publisher, closePublisher, err := newPublisher(bus, webhooks.NewDispatcher(webhooksRepo))
+	if err != nil {
+		return err
+	}
+	defer closePublisher()
+
+	creds, err := transportCredentials(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Health is served before the repositories are initialised, so that
+	// orchestrators can observe the NOT_SERVING state while we start up.
+	// Racing calls are refused until then, as the database may not have
+	// been migrated yet.
+	healthChecker := health.NewChecker(racingDB, racing.Racing_ServiceDesc.ServiceName)
+
+	grpcServer := grpc.NewServer(
+		grpc.Creds(creds),
+		grpc.ChainUnaryInterceptor(
+			otelgrpc.UnaryServerInterceptor(),
+			logging.UnaryServerInterceptor(logger),
+			grpc_prometheus.UnaryServerInterceptor,
+			apperr.UnaryServerInterceptor(),
+			healthChecker.UnaryServerInterceptor(),
+			validation.UnaryServerInterceptor(),
+			logging.UnaryRecoveryInterceptor(),
+		),
+		grpc.ChainStreamInterceptor(
+			otelgrpc.StreamServerInterceptor(),
+			logging.StreamServerInterceptor(logger),
+			grpc_prometheus.StreamServerInterceptor,
+			apperr.StreamServerInterceptor(),
+			healthChecker.StreamServerInterceptor(),
+			validation.StreamServerInterceptor(),
+			logging.StreamRecoveryInterceptor(),
+		),
+	)
+
+	healthpb.RegisterHealthServer(grpcServer, healthChecker)
 
 	racing.RegisterRacingServer(
 		grpcServer,
 		service.NewRacingService(
 			racesRepo,
+			rulesRepo,
+			db.NewAuditRepo(racingDB, *queryTimeout),
+			webhooksRepo,
+			remindersRepo,
+			blackbookRepo,
+			webhookPolicy(),
 		),
 	)
 
-	log.Printf("gRPC server listening on: %s\n", *grpcEndpoint)
+	grpc_prometheus.EnableHandlingTimeHistogram()
+	grpc_prometheus.Register(grpcServer)
+
+	go serveMetrics(logger, *metricsEndpoint)
+
+	logger.Info("gRPC server listening", zap.String("endpoint", *grpcEndpoint))
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- grpcServer.Serve(conn)
+	}()
+
+	go func() {
+		<-ctx.Done()
+		// Health checks fail while in-flight calls drain, so that load
+		// balancers stop routing new calls here.
+		healthChecker.Shutdown()
+		grpcServer.GracefulStop()
+	}()
+
+	if err := racesRepo.Init(ctx); err != nil {
+		grpcServer.Stop()
+		return err
+	}
+
+	if err := webhooksRepo.Init(ctx); err != nil {
+		grpcServer.Stop()
+		return err
+	}
+
+	if err := remindersRepo.Init(ctx); err != nil {
+		grpcServer.Stop()
+		return err
+	}
 
-	if err := grpcServer.Serve(conn); err != nil {
+	if err := blackbookRepo.Init(ctx); err != nil {
+		grpcServer.Stop()
 		return err
 	}
 
-	return nil
+	// The outbox is only relayed once Init has created it.
+	relay := outbox.NewRelay(db.NewOutboxRepo(racingDB, *queryTimeout), publisher, *outboxInterval, *outboxBatchSize)
+	go relay.Run(ctx)
+	go deliverer.Run(ctx)
+	go scheduler.Run(ctx)
+
+	healthChecker.MarkReady(ctx)
+	go healthChecker.Monitor(ctx, *healthCheckInterval)
+
+	return <-serveErr
+}
+
+// newPublisher returns the publisher race events are relayed to, as
+// configured by the event flags, along with a function releasing it.
+func newPublisher(bus *events.Bus, dispatcher *webhooks.Dispatcher) (events.Publisher, func(), error) {
+	if *outboxInterval <= 0 || *outboxBatchSize <= 0 {
+		return nil, nil, errors.New("outbox interval and batch size must be positive")
+	}
+
+	// Webhook deliveries are queued before the bus is published to, so that
+	// an event is only relayed once its deliveries are safely stored.
+	if *eventLog == "" {
+		return events.Multi(dispatcher, bus), func() {}, nil
+	}
+
+	log, err := events.OpenFileLog(*eventLog)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Events are logged first, so that the log holds everything subscribers
+	// have seen.
+	return events.Multi(log, dispatcher, bus), func() { log.Close() }, nil
+}
+
+// newDeliverer returns the webhook deliverer configured by the webhook flags.
+func newDeliverer(repo db.WebhooksRepo) (*webhooks.Deliverer, error) {
+	if *webhookMaxAttempts <= 0 || *webhookInitialBackoff <= 0 || *webhookMaxBackoff < *webhookInitialBackoff {
+		return nil, errors.New("webhook attempts and backoff must be positive, with the maximum backoff no less than the initial backoff")
+	}
+
+	if *webhookTimeout <= 0 || *webhookPollInterval <= 0 {
+		return nil, errors.New("webhook timeout and poll interval must be positive")
+	}
+
+	return webhooks.NewDeliverer(repo, webhooks.Config{
+		MaxAttempts:    *webhookMaxAttempts,
+		InitialBackoff: *webhookInitialBackoff,
+		MaxBackoff:     *webhookMaxBackoff,
+		Timeout:        *webhookTimeout,
+		PollInterval:   *webhookPollInterval,
+		Policy:         webhookPolicy(),
+	}), nil
+}
+
+// webhookPolicy returns the webhook endpoint policy configured by the webhook
+// flags.
+func webhookPolicy() webhooks.Policy {
+	return webhooks.Policy{
+		AllowInsecure: *webhookAllowInsecure,
+		AllowPrivate:  *webhookAllowPrivate,
+	}
+}
+
+// newScheduler returns the race reminder scheduler configured by the reminder
+// flags.
+func newScheduler(repo db.RemindersRepo, rules db.RulesRepo) (*reminders.Scheduler, error) {
+	if *reminderInterval <= 0 {
+		return nil, errors.New("reminder interval must be positive")
+	}
+
+	var offsets []time.Duration
+
+	for _, s := range strings.Split(*reminderOffsets, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+
+		offset, err := time.ParseDuration(s)
+		if err != nil || offset < time.Second {
+			return nil, fmt.Errorf("invalid -reminder-offsets %q: offsets must be durations of at least a second", s)
+		}
+
+		offsets = append(offsets, offset.Truncate(time.Second))
+	}
+
+	return reminders.NewScheduler(repo, rules, offsets, *reminderInterval), nil
+}
+
+// newSeedConfig returns the seeding configuration given by the seed flags.
+func newSeedConfig() (db.SeedConfig, error) {
+	cfg := db.SeedConfig{
+		Seed:            *seed,
+		Meetings:        *seedMeetings,
+		RacesPerMeeting: *seedRacesPerMeeting,
+		SpreadBefore:    *seedSpreadBefore,
+		SpreadAfter:     *seedSpreadAfter,
+		Reset:           *seedReset,
+	}
+
+	if cfg.Meetings < 0 || cfg.RacesPerMeeting < 0 || cfg.SpreadBefore < 0 || cfg.SpreadAfter < 0 {
+		return cfg, errors.New("seed volumes and spreads must not be negative")
+	}
+
+	if *seedStart != "" {
+		start, err := time.Parse(time.RFC3339, *seedStart)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid -seed-start: %w", err)
+		}
+
+		cfg.Start = start
+	}
+
+	for _, fixture := range strings.Split(*seedFixtures, ",") {
+		if fixture = strings.TrimSpace(fixture); fixture != "" {
+			cfg.Fixtures = append(cfg.Fixtures, fixture)
+		}
+	}
+
+	return cfg, nil
+}
+
+// transportCredentials returns the credentials configured by the TLS flags,
+// falling back to plaintext when no certificate is given.
+func transportCredentials(ctx context.Context) (credentials.TransportCredentials, error) {
+	if *tlsCert == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	cfg, err := tlsconfig.Server(ctx, tlsconfig.Options{
+		CertFile:       *tlsCert,
+		KeyFile:        *tlsKey,
+		CAFile:         *tlsClientCA,
+		ReloadInterval: *tlsReloadInterval,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials.NewTLS(cfg), nil
+}
+
+// serveMetrics exposes the default Prometheus registry over HTTP.
+func serveMetrics(logger *zap.Logger, endpoint string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	logger.Info("metrics server listening", zap.String("endpoint", endpoint))
+
+	if err := http.ListenAndServe(endpoint, mux); err != nil {
+		logger.Error("failed running metrics server", zap.Error(err))
+	}
 }
@@ -0,0 +1,113 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/events"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// maxBackoff bounds how long the relay waits between failed attempts.
+const maxBackoff = time.Minute
+
+var (
+	published = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "racing",
+		Subsystem: "outbox",
+		Name:      "published_total",
+		Help:      "Total number of outbox events published.",
+	})
+
+	publishErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "racing",
+		Subsystem: "outbox",
+		Name:      "publish_errors_total",
+		Help:      "Total number of failed attempts to publish outbox events.",
+	})
+)
+
+// Relay publishes the events in the outbox, in order. An event is only
+// marked as published once the publisher has accepted it, so events are
+// delivered at least once, even across restarts.
+type Relay struct {
+	repo      db.OutboxRepo
+	publisher events.Publisher
+	interval  time.Duration
+	batchSize int
+}
+
+// NewRelay returns a relay polling repo every interval for up to batchSize
+// events to publish.
+func NewRelay(repo db.OutboxRepo, publisher events.Publisher, interval time.Duration, batchSize int) *Relay {
+	return &Relay{repo: repo, publisher: publisher, interval: interval, batchSize: batchSize}
+}
+
+// Run publishes events until ctx is done. Failures are retried with
+// exponential backoff.
+func (r *Relay) Run(ctx context.Context) {
+	wait := r.interval
+
+	for {
+		if err := r.drain(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			publishErrors.Inc()
+			zap.L().Warn("failed relaying outbox events", zap.Duration("retry_in", wait), zap.Error(err))
+
+			wait *= 2
+			if wait > maxBackoff {
+				wait = maxBackoff
+			}
+		} else {
+			wait = r.interval
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// drain publishes pending events until none remain.
+func (r *Relay) drain(ctx context.Context) error {
+	for {
+		pending, err := r.repo.Pending(ctx, r.batchSize)
+		if err != nil {
+			return err
+		}
+
+		var ids []int64
+
+		for _, event := range pending {
+			if err = r.publisher.Publish(ctx, event); err != nil {
+				break
+			}
+
+			ids = append(ids, event.ID)
+		}
+
+		// Record what was published before reporting any failure, so that
+		// it is not published again.
+		if markErr := r.repo.MarkPublished(ctx, ids); markErr != nil {
+			return markErr
+		}
+
+		published.Add(float64(len(ids)))
+
+		if err != nil {
+			return err
+		}
+
+		if len(pending) < r.batchSize {
+			return nil
+		}
+	}
+}
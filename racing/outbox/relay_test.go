@@ -0,0 +1,92 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"git.neds.sh/matty/entain/racing/events"
+)
+
+// memoryOutbox is an outbox holding its events in memory.
+type memoryOutbox struct {
+	events    []events.Event
+	published []int64
+}
+
+func (o *memoryOutbox) Pending(_ context.Context, limit int) ([]events.Event, error) {
+	var pending []events.Event
+
+	for _, event := range o.events {
+		if len(pending) == limit {
+			break
+		}
+
+		if !o.isPublished(event.ID) {
+			pending = append(pending, event)
+		}
+	}
+
+	return pending, nil
+}
+
+func (o *memoryOutbox) MarkPublished(_ context.Context, ids []int64) error {
+	o.published = append(o.published, ids...)
+	return nil
+}
+
+func (o *memoryOutbox) isPublished(id int64) bool {
+	for _, published := range o.published {
+		if published == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// failingPublisher accepts events until it is given the event failAt.
+type failingPublisher struct {
+	failAt    int64
+	published []int64
+}
+
+func (p *failingPublisher) Publish(_ context.Context, event events.Event) error {
+	if event.ID == p.failAt {
+		return errors.New("publisher unavailable")
+	}
+
+	p.published = append(p.published, event.ID)
+
+	return nil
+}
+
+func TestDrainMarksPartlyPublishedBatch(t *testing.T) {
+	outbox := &memoryOutbox{}
+	for id := int64(1); id <= 5; id++ {
+		outbox.events = append(outbox.events, events.Event{ID: id, Type: events.RaceUpdated})
+	}
+
+	publisher := &failingPublisher{failAt: 3}
+	relay := NewRelay(outbox, publisher, 0, 10)
+
+	if err := relay.drain(context.Background()); err == nil {
+		t.Fatal("got no error, want the publisher's")
+	}
+
+	if want := []int64{1, 2}; !reflect.DeepEqual(outbox.published, want) {
+		t.Fatalf("got events %v marked published, want %v", outbox.published, want)
+	}
+
+	// Once the publisher recovers, only the remaining events are published.
+	publisher.failAt = 0
+
+	if err := relay.drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int64{1, 2, 3, 4, 5}; !reflect.DeepEqual(publisher.published, want) {
+		t.Errorf("got events %v published, want %v", publisher.published, want)
+	}
+}
@@ -0,0 +1,118 @@
+package reminders
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/jurisdiction"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// batchSize bounds the number of reminders sent per query.
+const batchSize = 100
+
+var sent = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "racing",
+	Subsystem: "reminders",
+	Name:      "sent_total",
+	Help:      "Total number of race reminders queued for sending.",
+})
+
+// Scheduler sends reminders that races are about to start, at each of its
+// offsets before their advertised start. Reminders are queued in the outbox,
+// and published as race.reminder events like any other.
+//
+// A reminder is only sent until the next, shorter, offset falls due, so that
+// a scheduler that was down does not send a burst of stale reminders when it
+// catches up. Reminders of races the customer's jurisdiction may not see are
+// withheld.
+type Scheduler struct {
+	repo     db.RemindersRepo
+	rules    db.RulesRepo
+	offsets  []time.Duration
+	interval time.Duration
+}
+
+// NewScheduler returns a scheduler checking repo every interval for reminders
+// due at the given offsets, applying the jurisdiction rules in rules.
+func NewScheduler(repo db.RemindersRepo, rules db.RulesRepo, offsets []time.Duration, interval time.Duration) *Scheduler {
+	sorted := append([]time.Duration(nil), offsets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+
+	s := &Scheduler{repo: repo, rules: rules, interval: interval}
+	for _, offset := range sorted {
+		if len(s.offsets) == 0 || s.offsets[len(s.offsets)-1] != offset {
+			s.offsets = append(s.offsets, offset)
+		}
+	}
+
+	return s
+}
+
+// Run sends reminders as they fall due, until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		if err := s.send(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			zap.L().Warn("failed sending race reminders", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// send sends the reminders due at now.
+func (s *Scheduler) send(ctx context.Context, now time.Time) error {
+	rules, err := s.rules.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	policy := jurisdiction.NewPolicy(rules)
+
+	for i, offset := range s.offsets {
+		var after time.Duration
+		if i+1 < len(s.offsets) {
+			after = s.offsets[i+1]
+		}
+
+		for {
+			due, err := s.repo.Due(ctx, now, offset, after, batchSize)
+			if err != nil {
+				return err
+			}
+
+			if len(due) == 0 {
+				break
+			}
+
+			var queued int
+
+			for i, reminder := range due {
+				due[i].Withheld = policy.Check(reminder.Race.Id, reminder.Race.MeetingId, reminder.Jurisdiction) != ""
+				if !due[i].Withheld {
+					queued++
+				}
+			}
+
+			if err := s.repo.Send(ctx, due); err != nil {
+				return err
+			}
+
+			sent.Add(float64(queued))
+
+			if len(due) < batchSize {
+				break
+			}
+		}
+	}
+
+	return nil
+}
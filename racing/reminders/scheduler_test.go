@@ -0,0 +1,247 @@
+package reminders
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/events"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fixture is a scheduler sending reminders at 10m and 2m before the start of
+// races in a temporary database.
+type fixture struct {
+	t         *testing.T
+	db        *sql.DB
+	races     db.RacesRepo
+	reminders db.RemindersRepo
+	outbox    db.OutboxRepo
+	scheduler *Scheduler
+}
+
+func newFixture(t *testing.T) *fixture {
+	ctx := context.Background()
+
+	racingDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "racing.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { racingDB.Close() })
+
+	f := &fixture{
+		t:         t,
+		db:        racingDB,
+		races:     db.NewRacesRepo(racingDB, 0, db.SeedConfig{}),
+		reminders: db.NewRemindersRepo(racingDB, 0),
+		outbox:    db.NewOutboxRepo(racingDB, 0),
+	}
+
+	if err := f.races.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.reminders.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	f.scheduler = NewScheduler(f.reminders, db.NewRulesRepo(racingDB, 0), []time.Duration{10 * time.Minute, 2 * time.Minute}, time.Second)
+
+	return f
+}
+
+// race imports a race of brand starting at start.
+func (f *fixture) race(brand string, id int64, visible bool, start time.Time) {
+	f.t.Helper()
+
+	ctx := context.Background()
+
+	imp, err := f.races.BeginImport(ctx, brand)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	race := &racing.Race{Id: id, MeetingId: id, Name: fmt.Sprint("Race ", id), Number: 1, Visible: visible, AdvertisedStartTime: timestamppb.New(start)}
+	if _, err := imp.Write(ctx, []*racing.Race{race}); err != nil {
+		imp.Rollback()
+		f.t.Fatal(err)
+	}
+
+	if err := imp.Commit(); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fixture) subscribe(customer, brand, jurisdiction string, raceID int64) {
+	f.t.Helper()
+
+	if _, err := f.reminders.Subscribe(context.Background(), customer, brand, jurisdiction, raceID); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fixture) exec(query string, args ...interface{}) {
+	f.t.Helper()
+
+	if _, err := f.db.ExecContext(context.Background(), query, args...); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// send runs the scheduler at now, returning the reminders it queued as
+// "<customer> <race ID> <offset> <advertised start>".
+func (f *fixture) send(now time.Time) []string {
+	f.t.Helper()
+
+	ctx := context.Background()
+
+	if err := f.scheduler.send(ctx, now); err != nil {
+		f.t.Fatal(err)
+	}
+
+	pending, err := f.outbox.Pending(ctx, 100)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	var (
+		got []string
+		ids []int64
+	)
+
+	for _, event := range pending {
+		ids = append(ids, event.ID)
+
+		if event.Type != events.RaceReminder {
+			continue
+		}
+
+		var data events.ReminderData
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			f.t.Fatal(err)
+		}
+
+		race, err := event.DecodeRace()
+		if err != nil {
+			f.t.Fatal(err)
+		}
+
+		got = append(got, fmt.Sprintf("%s %d %s %s", event.Customer, event.RaceID, time.Duration(data.OffsetSeconds)*time.Second, race.AdvertisedStartTime.AsTime().Format("15:04")))
+	}
+
+	if err := f.outbox.MarkPublished(ctx, ids); err != nil {
+		f.t.Fatal(err)
+	}
+
+	return got
+}
+
+func TestRescheduledRaceRemindedAgain(t *testing.T) {
+	f := newFixture(t)
+
+	start := time.Date(2030, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	f.race("neds", 1, true, start)
+	f.subscribe("alice", "neds", "", 1)
+
+	if got, want := f.send(start.Add(-9*time.Minute)), []string{"alice 1 10m0s 12:00"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got reminders %v, want %v", got, want)
+	}
+
+	if got := f.send(start.Add(-8 * time.Minute)); got != nil {
+		t.Fatalf("got reminders %v sent twice, want none", got)
+	}
+
+	// The race is pushed back half an hour, so its reminders fall due again.
+	start = start.Add(30 * time.Minute)
+	f.race("neds", 1, true, start)
+
+	if got := f.send(start.Add(-15 * time.Minute)); got != nil {
+		t.Fatalf("got reminders %v before the new start's window, want none", got)
+	}
+
+	if got, want := f.send(start.Add(-9*time.Minute)), []string{"alice 1 10m0s 12:30"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got reminders %v, want %v", got, want)
+	}
+
+	if got, want := f.send(start.Add(-time.Minute)), []string{"alice 1 2m0s 12:30"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got reminders %v, want %v", got, want)
+	}
+}
+
+func TestNoStaleRemindersAfterDowntime(t *testing.T) {
+	f := newFixture(t)
+
+	start := time.Date(2030, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	f.race("neds", 1, true, start)
+	f.race("neds", 2, true, start.Add(5*time.Minute))
+	f.subscribe("alice", "neds", "", 1)
+	f.subscribe("alice", "neds", "", 2)
+
+	// The scheduler was down through both races' 10m reminders, and race 1's
+	// 2m reminder, so only race 2's current reminder is sent.
+	if got, want := f.send(start.Add(time.Minute)), []string{"alice 2 10m0s 12:05"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got reminders %v, want %v", got, want)
+	}
+
+	if got, want := f.send(start.Add(4*time.Minute)), []string{"alice 2 2m0s 12:05"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got reminders %v, want %v", got, want)
+	}
+
+	if got := f.send(start.Add(time.Hour)); got != nil {
+		t.Fatalf("got reminders %v after the races started, want none", got)
+	}
+}
+
+func TestRemindersOnlyForVisibleRaces(t *testing.T) {
+	f := newFixture(t)
+
+	start := time.Date(2030, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	f.race("neds", 1, true, start)
+	f.race("neds", 2, false, start)
+	f.race("", 3, true, start)
+	f.race("ladbrokes", 4, true, start)
+	f.race("", 5, true, start)
+	f.race("neds", 6, true, start)
+
+	// Race 5 is hidden by neds, and race 6 is blocked in New South Wales.
+	f.exec(`INSERT INTO race_visibility_overrides (brand, race_id, visible) VALUES ('neds', 5, 0)`)
+	f.exec(`INSERT INTO jurisdiction_rules (race_id, region, effect) VALUES (6, 'AU-NSW', 'block')`)
+
+	for id := int64(1); id <= 6; id++ {
+		f.subscribe("alice", "neds", "AU-NSW", id)
+	}
+	f.subscribe("bob", "neds", "AU-VIC", 6)
+
+	want := []string{"alice 1 10m0s 12:00", "alice 3 10m0s 12:00", "bob 6 10m0s 12:00"}
+	if got := f.send(start.Add(-9 * time.Minute)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got reminders %v, want %v", got, want)
+	}
+
+	// Withheld reminders are not due again.
+	due, err := f.reminders.Due(context.Background(), start.Add(-8*time.Minute), 10*time.Minute, 2*time.Minute, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(due) != 0 {
+		t.Fatalf("got %d reminders due, want none", len(due))
+	}
+
+	// Races shown again are reminded at their next offset.
+	f.exec(`UPDATE races SET visible = 1 WHERE id = 2`)
+
+	want = []string{"alice 1 2m0s 12:00", "alice 2 2m0s 12:00", "alice 3 2m0s 12:00", "bob 6 2m0s 12:00"}
+	if got := f.send(start.Add(-time.Minute)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got reminders %v, want %v", got, want)
+	}
+}
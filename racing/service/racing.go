@@ -1,31 +1,572 @@
 package service
 
 import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/apperr"
+	"git.neds.sh/matty/entain/racing/audit"
+	"git.neds.sh/matty/entain/racing/caller"
 	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/importer"
+	"git.neds.sh/matty/entain/racing/jurisdiction"
 	"git.neds.sh/matty/entain/racing/proto/racing"
+	"git.neds.sh/matty/entain/racing/webhooks"
+	"github.com/golang/protobuf/ptypes"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/context"
+	"google.golang.org/protobuf/proto"
 )
 
+var tracer = otel.Tracer("git.neds.sh/matty/entain/racing/service")
+
 type Racing interface {
 	// ListRaces will return a collection of races.
 	ListRaces(ctx context.Context, in *racing.ListRacesRequest) (*racing.ListRacesResponse, error)
+
+	// GetRace will return a single race by its ID.
+	GetRace(ctx context.Context, in *racing.GetRaceRequest) (*racing.Race, error)
+
+	// ExportRaces will stream the races selected by the filter.
+	ExportRaces(in *racing.ExportRacesRequest, stream racing.Racing_ExportRacesServer) error
+
+	// ImportRaces will upsert the races streamed in by an administrator.
+	ImportRaces(stream racing.Racing_ImportRacesServer) error
+
+	// ListAuditEvents will return the audit log of race changes.
+	ListAuditEvents(ctx context.Context, in *racing.ListAuditEventsRequest) (*racing.ListAuditEventsResponse, error)
+
+	// RegisterWebhook will subscribe a URL to race events.
+	RegisterWebhook(ctx context.Context, in *racing.RegisterWebhookRequest) (*racing.Webhook, error)
+
+	// ListWebhookDeliveries will return the delivery log of a webhook.
+	ListWebhookDeliveries(ctx context.Context, in *racing.ListWebhookDeliveriesRequest) (*racing.ListWebhookDeliveriesResponse, error)
+
+	// SubscribeRaceReminders will remind the caller that a race is about to
+	// start.
+	SubscribeRaceReminders(ctx context.Context, in *racing.SubscribeRaceRemindersRequest) (*racing.RaceReminderSubscription, error)
+
+	// UnsubscribeRaceReminders will stop reminding the caller of a race.
+	UnsubscribeRaceReminders(ctx context.Context, in *racing.UnsubscribeRaceRemindersRequest) (*racing.UnsubscribeRaceRemindersResponse, error)
+
+	// Follow will add an entity to the caller's blackbook.
+	Follow(ctx context.Context, in *racing.FollowRequest) (*racing.BlackbookEntry, error)
+
+	// Unfollow will remove an entity from the caller's blackbook.
+	Unfollow(ctx context.Context, in *racing.UnfollowRequest) (*racing.UnfollowResponse, error)
+
+	// ListFollows will return the caller's blackbook.
+	ListFollows(ctx context.Context, in *racing.ListFollowsRequest) (*racing.ListFollowsResponse, error)
 }
 
+const (
+	// defaultAuditLimit is the number of audit events returned when the
+	// request does not set a limit.
+	defaultAuditLimit = 100
+
+	// defaultDeliveriesLimit is the number of webhook deliveries returned
+	// when the request does not set a limit.
+	defaultDeliveriesLimit = 100
+
+	// maxImportedRaces bounds the number of races an ImportRaces stream may
+	// send, as they are all held in memory until the stream ends.
+	maxImportedRaces = 20000
+)
+
 // racingService implements the Racing interface.
 type racingService struct {
-	racesRepo db.RacesRepo
+	racesRepo     db.RacesRepo
+	rulesRepo     db.RulesRepo
+	auditRepo     db.AuditRepo
+	webhooksRepo  db.WebhooksRepo
+	remindersRepo db.RemindersRepo
+	blackbookRepo db.BlackbookRepo
+
+	// webhookPolicy restricts the URLs webhooks may be registered with.
+	webhookPolicy webhooks.Policy
 }
 
 // NewRacingService instantiates and returns a new racingService.
-func NewRacingService(racesRepo db.RacesRepo) Racing {
-	return &racingService{racesRepo}
+func NewRacingService(racesRepo db.RacesRepo, rulesRepo db.RulesRepo, auditRepo db.AuditRepo, webhooksRepo db.WebhooksRepo, remindersRepo db.RemindersRepo, blackbookRepo db.BlackbookRepo, webhookPolicy webhooks.Policy) Racing {
+	return &racingService{racesRepo, rulesRepo, auditRepo, webhooksRepo, remindersRepo, blackbookRepo, webhookPolicy}
 }
 
 func (s *racingService) ListRaces(ctx context.Context, in *racing.ListRacesRequest) (*racing.ListRacesResponse, error) {
-	races, err := s.racesRepo.List(in.Filter)
+	ctx, span := tracer.Start(ctx, "racingService.ListRaces", trace.WithAttributes(
+		attribute.Int64Slice("racing.filter.meeting_ids", in.Filter.GetMeetingIds()),
+	))
+	defer span.End()
+
+	c := caller.FromContext(ctx)
+	span.SetAttributes(attribute.String("racing.caller.brand", c.Brand))
+
+	races, err := s.racesRepo.List(ctx, c.Brand, in.Filter)
 	if err != nil {
-		return nil, err
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
+	}
+
+	races, err = s.restrict(ctx, c, races)
+	if err != nil {
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
 	}
 
+	span.SetAttributes(attribute.Int("racing.races.count", len(races)))
+
 	return &racing.ListRacesResponse{Races: races}, nil
 }
+
+func (s *racingService) GetRace(ctx context.Context, in *racing.GetRaceRequest) (*racing.Race, error) {
+	ctx, span := tracer.Start(ctx, "racingService.GetRace", trace.WithAttributes(
+		attribute.Int64("racing.race.id", in.Id),
+	))
+	defer span.End()
+
+	c := caller.FromContext(ctx)
+	span.SetAttributes(attribute.String("racing.caller.brand", c.Brand))
+
+	race, err := s.racesRepo.Get(ctx, c.Brand, in.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperr.NotFound("race", in.Id)
+	}
+	if err != nil {
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
+	}
+
+	races, err := s.restrict(ctx, c, []*racing.Race{race})
+	if err != nil {
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
+	}
+
+	// Restricted races are reported as missing, so as not to reveal them.
+	if len(races) == 0 {
+		return nil, apperr.NotFound("race", in.Id)
+	}
+
+	return races[0], nil
+}
+
+// restrict applies the jurisdiction rules to races for c. Excluded races are
+// removed, except for administrators, who instead see why they are excluded.
+// races may be shared with the cache, so they are never modified.
+func (s *racingService) restrict(ctx context.Context, c caller.Caller, races []*racing.Race) ([]*racing.Race, error) {
+	rules, err := s.rulesRepo.List(ctx)
+	if err != nil || len(rules) == 0 {
+		return races, err
+	}
+
+	var (
+		policy   = jurisdiction.NewPolicy(rules)
+		allowed  = races[:0:0]
+		excluded int
+	)
+
+	for _, race := range races {
+		reason := policy.Check(race.Id, race.MeetingId, c.Jurisdiction)
+		if reason == "" {
+			allowed = append(allowed, race)
+			continue
+		}
+
+		excluded++
+
+		if c.Admin {
+			race = proto.Clone(race).(*racing.Race)
+			race.ExclusionReason = reason
+			allowed = append(allowed, race)
+		}
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("racing.caller.jurisdiction", c.Jurisdiction),
+		attribute.Int("racing.races.excluded", excluded),
+	)
+
+	return allowed, nil
+}
+
+func (s *racingService) ExportRaces(in *racing.ExportRacesRequest, stream racing.Racing_ExportRacesServer) error {
+	ctx, span := tracer.Start(stream.Context(), "racingService.ExportRaces", trace.WithAttributes(
+		attribute.Int64Slice("racing.filter.meeting_ids", in.Filter.GetMeetingIds()),
+	))
+	defer span.End()
+
+	c := caller.FromContext(ctx)
+	span.SetAttributes(attribute.String("racing.caller.brand", c.Brand))
+
+	races, err := s.racesRepo.List(ctx, c.Brand, in.Filter)
+	if err != nil {
+		recordError(span, err)
+		return apperr.FromRepository(err)
+	}
+
+	races, err = s.restrict(ctx, c, races)
+	if err != nil {
+		recordError(span, err)
+		return apperr.FromRepository(err)
+	}
+
+	span.SetAttributes(attribute.Int("racing.races.count", len(races)))
+
+	for _, race := range races {
+		if err := stream.Send(race); err != nil {
+			recordError(span, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *racingService) ImportRaces(stream racing.Racing_ImportRacesServer) error {
+	ctx, span := tracer.Start(stream.Context(), "racingService.ImportRaces")
+	defer span.End()
+
+	c := caller.FromContext(ctx)
+	if c.Subject == "" || !c.Admin {
+		return apperr.PermissionDenied("races may only be imported by administrators")
+	}
+
+	ctx = audit.WithActor(ctx, c.Subject)
+
+	// The whole stream is received before anything is written, so that the
+	// import's transaction is not held open while waiting on the client.
+	var (
+		races  []*racing.Race
+		dryRun bool
+	)
+
+	for first := true; ; first = false {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			recordError(span, err)
+			return err
+		}
+
+		if first {
+			dryRun = in.DryRun
+		}
+
+		if len(races)+len(in.Races) > maxImportedRaces {
+			return apperr.InvalidArgument(apperr.FieldViolation{
+				Field:       "races",
+				Description: fmt.Sprintf("must not contain more than %d races across the stream", maxImportedRaces),
+			})
+		}
+
+		races = append(races, in.Races...)
+	}
+
+	imp := importer.New(s.racesRepo, c.Brand, dryRun)
+	defer imp.Abort()
+
+	for _, race := range races {
+		if err := imp.Add(ctx, importer.Row{Race: race}); err != nil {
+			recordError(span, err)
+			return apperr.FromRepository(err)
+		}
+	}
+
+	result, err := imp.Close(ctx)
+	if err != nil {
+		recordError(span, err)
+		return apperr.FromRepository(err)
+	}
+
+	span.SetAttributes(
+		attribute.Bool("racing.import.dry_run", result.DryRun),
+		attribute.Int("racing.import.received", result.Received),
+		attribute.Int("racing.import.created", result.Created),
+		attribute.Int("racing.import.updated", result.Updated),
+		attribute.Int("racing.import.rejected", result.Rejected),
+	)
+
+	return stream.SendAndClose(importResponse(result))
+}
+
+func (s *racingService) ListAuditEvents(ctx context.Context, in *racing.ListAuditEventsRequest) (*racing.ListAuditEventsResponse, error) {
+	ctx, span := tracer.Start(ctx, "racingService.ListAuditEvents", trace.WithAttributes(
+		attribute.Int64("racing.filter.race_id", in.Filter.GetRaceId()),
+	))
+	defer span.End()
+
+	c := caller.FromContext(ctx)
+	if !c.Admin {
+		return nil, apperr.PermissionDenied("the audit log is only available to administrators")
+	}
+
+	filter := db.AuditFilter{
+		RaceID: in.Filter.GetRaceId(),
+		Actor:  in.Filter.GetActor(),
+	}
+
+	if in.Filter.GetStartTime() != nil {
+		filter.Start = in.Filter.GetStartTime().AsTime()
+	}
+
+	if in.Filter.GetEndTime() != nil {
+		filter.End = in.Filter.GetEndTime().AsTime()
+	}
+
+	limit := int(in.Limit)
+	if limit == 0 {
+		limit = defaultAuditLimit
+	}
+
+	events, err := s.auditRepo.List(ctx, c.Brand, filter, limit)
+	if err != nil {
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
+	}
+
+	span.SetAttributes(attribute.Int("racing.audit_events.count", len(events)))
+
+	return &racing.ListAuditEventsResponse{Events: events}, nil
+}
+
+func (s *racingService) RegisterWebhook(ctx context.Context, in *racing.RegisterWebhookRequest) (*racing.Webhook, error) {
+	ctx, span := tracer.Start(ctx, "racingService.RegisterWebhook")
+	defer span.End()
+
+	c := caller.FromContext(ctx)
+	if c.Subject == "" {
+		return nil, apperr.PermissionDenied("webhooks may only be registered by authenticated callers")
+	}
+
+	if err := s.webhookPolicy.ValidateURL(ctx, in.Url); err != nil {
+		return nil, apperr.InvalidArgument(apperr.FieldViolation{Field: "url", Description: err.Error()})
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
+	}
+
+	webhook := &db.Webhook{
+		URL:        in.Url,
+		EventTypes: in.EventTypes,
+		Secret:     secret,
+		Owner:      c.Subject,
+		Brand:      c.Brand,
+		CreatedAt:  time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	if err := s.webhooksRepo.Create(ctx, webhook); err != nil {
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
+	}
+
+	span.SetAttributes(attribute.Int64("racing.webhook.id", webhook.ID))
+
+	createdAt, err := ptypes.TimestampProto(webhook.CreatedAt)
+	if err != nil {
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
+	}
+
+	return &racing.Webhook{
+		Id:         webhook.ID,
+		Url:        webhook.URL,
+		EventTypes: webhook.EventTypes,
+		Secret:     webhook.Secret,
+		CreatedAt:  createdAt,
+	}, nil
+}
+
+func (s *racingService) ListWebhookDeliveries(ctx context.Context, in *racing.ListWebhookDeliveriesRequest) (*racing.ListWebhookDeliveriesResponse, error) {
+	ctx, span := tracer.Start(ctx, "racingService.ListWebhookDeliveries", trace.WithAttributes(
+		attribute.Int64("racing.webhook.id", in.WebhookId),
+	))
+	defer span.End()
+
+	webhook, err := s.webhooksRepo.Get(ctx, in.WebhookId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperr.NotFound("webhook", in.WebhookId)
+	}
+	if err != nil {
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
+	}
+
+	// Webhooks are only visible to their owner and the brand's
+	// administrators, and are otherwise reported as missing.
+	c := caller.FromContext(ctx)
+	if webhook.Owner != c.Subject && !(c.Admin && webhook.Brand == c.Brand) {
+		return nil, apperr.NotFound("webhook", in.WebhookId)
+	}
+
+	limit := int(in.Limit)
+	if limit == 0 {
+		limit = defaultDeliveriesLimit
+	}
+
+	deliveries, err := s.webhooksRepo.Deliveries(ctx, webhook.ID, in.Status, limit)
+	if err != nil {
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
+	}
+
+	span.SetAttributes(attribute.Int("racing.webhook_deliveries.count", len(deliveries)))
+
+	return &racing.ListWebhookDeliveriesResponse{Deliveries: deliveries}, nil
+}
+
+func (s *racingService) SubscribeRaceReminders(ctx context.Context, in *racing.SubscribeRaceRemindersRequest) (*racing.RaceReminderSubscription, error) {
+	ctx, span := tracer.Start(ctx, "racingService.SubscribeRaceReminders", trace.WithAttributes(
+		attribute.Int64("racing.race.id", in.RaceId),
+	))
+	defer span.End()
+
+	c := caller.FromContext(ctx)
+	if c.Subject == "" {
+		return nil, apperr.PermissionDenied("race reminders are only available to authenticated callers")
+	}
+
+	// Callers may only subscribe to the races they may see.
+	if _, err := s.GetRace(ctx, &racing.GetRaceRequest{Id: in.RaceId}); err != nil {
+		return nil, err
+	}
+
+	createdAt, err := s.remindersRepo.Subscribe(ctx, c.Subject, c.Brand, c.Jurisdiction, in.RaceId)
+	if err != nil {
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
+	}
+
+	ts, err := ptypes.TimestampProto(createdAt)
+	if err != nil {
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
+	}
+
+	return &racing.RaceReminderSubscription{RaceId: in.RaceId, CreatedAt: ts}, nil
+}
+
+func (s *racingService) UnsubscribeRaceReminders(ctx context.Context, in *racing.UnsubscribeRaceRemindersRequest) (*racing.UnsubscribeRaceRemindersResponse, error) {
+	ctx, span := tracer.Start(ctx, "racingService.UnsubscribeRaceReminders", trace.WithAttributes(
+		attribute.Int64("racing.race.id", in.RaceId),
+	))
+	defer span.End()
+
+	c := caller.FromContext(ctx)
+	if c.Subject == "" {
+		return nil, apperr.PermissionDenied("race reminders are only available to authenticated callers")
+	}
+
+	if err := s.remindersRepo.Unsubscribe(ctx, c.Subject, in.RaceId); err != nil {
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
+	}
+
+	return &racing.UnsubscribeRaceRemindersResponse{}, nil
+}
+
+func (s *racingService) Follow(ctx context.Context, in *racing.FollowRequest) (*racing.BlackbookEntry, error) {
+	ctx, span := tracer.Start(ctx, "racingService.Follow", trace.WithAttributes(
+		attribute.String("racing.entity.type", in.EntityType),
+	))
+	defer span.End()
+
+	c := caller.FromContext(ctx)
+	if c.Subject == "" {
+		return nil, apperr.PermissionDenied("the blackbook is only available to authenticated callers")
+	}
+
+	entry, err := s.blackbookRepo.Follow(ctx, c.Subject, c.Brand, c.Jurisdiction, in.EntityType, in.EntityId)
+	if err != nil {
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
+	}
+
+	return entry, nil
+}
+
+func (s *racingService) Unfollow(ctx context.Context, in *racing.UnfollowRequest) (*racing.UnfollowResponse, error) {
+	ctx, span := tracer.Start(ctx, "racingService.Unfollow", trace.WithAttributes(
+		attribute.String("racing.entity.type", in.EntityType),
+	))
+	defer span.End()
+
+	c := caller.FromContext(ctx)
+	if c.Subject == "" {
+		return nil, apperr.PermissionDenied("the blackbook is only available to authenticated callers")
+	}
+
+	if err := s.blackbookRepo.Unfollow(ctx, c.Subject, in.EntityType, in.EntityId); err != nil {
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
+	}
+
+	return &racing.UnfollowResponse{}, nil
+}
+
+func (s *racingService) ListFollows(ctx context.Context, in *racing.ListFollowsRequest) (*racing.ListFollowsResponse, error) {
+	ctx, span := tracer.Start(ctx, "racingService.ListFollows")
+	defer span.End()
+
+	c := caller.FromContext(ctx)
+	if c.Subject == "" {
+		return nil, apperr.PermissionDenied("the blackbook is only available to authenticated callers")
+	}
+
+	entries, err := s.blackbookRepo.List(ctx, c.Subject, in.EntityType)
+	if err != nil {
+		recordError(span, err)
+		return nil, apperr.FromRepository(err)
+	}
+
+	span.SetAttributes(attribute.Int("racing.blackbook.count", len(entries)))
+
+	return &racing.ListFollowsResponse{Entries: entries}, nil
+}
+
+// newWebhookSecret returns a random secret for signing webhook deliveries.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+func importResponse(result *importer.Result) *racing.ImportRacesResponse {
+	resp := &racing.ImportRacesResponse{
+		Received: int64(result.Received),
+		Created:  int64(result.Created),
+		Updated:  int64(result.Updated),
+		Rejected: int64(result.Rejected),
+		DryRun:   result.DryRun,
+	}
+
+	for _, e := range result.Errors {
+		resp.Errors = append(resp.Errors, &racing.ImportRowError{
+			Row:         int64(e.Row),
+			Field:       e.Field,
+			Description: e.Description,
+		})
+	}
+
+	return resp
+}
+
+// recordError marks span as failed with err.
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(otelcodes.Error, err.Error())
+}
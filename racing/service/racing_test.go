@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"git.neds.sh/matty/entain/common/claims"
+	"git.neds.sh/matty/entain/racing/apperr"
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"git.neds.sh/matty/entain/racing/webhooks"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// importStream is an ImportRaces stream from a caller, which fails the test
+// if the service reads from it.
+type importStream struct {
+	grpc.ServerStream
+	t   *testing.T
+	ctx context.Context
+}
+
+func (s *importStream) Context() context.Context { return s.ctx }
+
+func (s *importStream) Recv() (*racing.ImportRacesRequest, error) {
+	s.t.Error("races were read from the stream")
+	return nil, errors.New("unexpected read")
+}
+
+func (s *importStream) SendAndClose(*racing.ImportRacesResponse) error {
+	s.t.Error("a response was sent")
+	return nil
+}
+
+// gatewayContext returns the context of a request from a gateway
+// authenticated with mutual TLS, which vouches for its caller.
+func gatewayContext() context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 50000},
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}},
+		},
+	})
+}
+
+func TestImportRacesPermissionDenied(t *testing.T) {
+	for name, md := range map[string]metadata.MD{
+		"anonymous":       metadata.Pairs(claims.BrandKey, "neds"),
+		"customer":        metadata.Pairs(claims.BrandKey, "neds", claims.SubjectKey, "customer-1"),
+		"anonymous admin": metadata.Pairs(claims.BrandKey, "neds", claims.AdminKey, "true"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := NewRacingService(nil, nil, nil, nil, nil, nil, webhooks.Policy{})
+
+			ctx := metadata.NewIncomingContext(gatewayContext(), md)
+
+			err := s.ImportRaces(&importStream{t: t, ctx: ctx})
+
+			var appErr *apperr.Error
+			if !errors.As(err, &appErr) || appErr.Code != codes.PermissionDenied {
+				t.Fatalf("got error %v, want permission denied", err)
+			}
+		})
+	}
+}
+
+// racesStream is an ImportRaces stream sending requests in turn.
+type racesStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	requests []*racing.ImportRacesRequest
+}
+
+func (s *racesStream) Context() context.Context { return s.ctx }
+
+func (s *racesStream) Recv() (*racing.ImportRacesRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+
+	in := s.requests[0]
+	s.requests = s.requests[1:]
+
+	return in, nil
+}
+
+func (s *racesStream) SendAndClose(*racing.ImportRacesResponse) error { return nil }
+
+// unwritableRepo is a races repository failing the test if an import begins.
+type unwritableRepo struct {
+	db.RacesRepo
+	t *testing.T
+}
+
+func (r unwritableRepo) BeginImport(context.Context, string) (db.RaceImport, error) {
+	r.t.Error("an import began")
+	return nil, errors.New("unexpected import")
+}
+
+func TestImportRacesTooLarge(t *testing.T) {
+	stream := &racesStream{
+		ctx: metadata.NewIncomingContext(
+			gatewayContext(),
+			metadata.Pairs(claims.SubjectKey, "admin-1", claims.AdminKey, "true"),
+		),
+	}
+
+	for n := 0; n <= maxImportedRaces; n += 1000 {
+		in := &racing.ImportRacesRequest{}
+		for i := 1; i <= 1000; i++ {
+			in.Races = append(in.Races, &racing.Race{Id: int64(n + i)})
+		}
+
+		stream.requests = append(stream.requests, in)
+	}
+
+	s := NewRacingService(unwritableRepo{t: t}, nil, nil, nil, nil, nil, webhooks.Policy{})
+
+	var appErr *apperr.Error
+	if err := s.ImportRaces(stream); !errors.As(err, &appErr) || appErr.Code != codes.InvalidArgument {
+		t.Fatalf("got error %v, want invalid argument", err)
+	}
+}
@@ -0,0 +1,259 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+
+	"git.neds.sh/matty/entain/racing/apperr"
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/events"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// maxMeetingIDs bounds the number of meetings a single filter may select.
+	maxMeetingIDs = 100
+
+	// maxImportRaces bounds the number of races in a single ImportRaces
+	// request. Larger imports are streamed over several requests.
+	maxImportRaces = 1000
+
+	// maxAuditEvents bounds the number of events ListAuditEvents returns.
+	maxAuditEvents = 1000
+
+	// maxWebhookDeliveries bounds the number of deliveries
+	// ListWebhookDeliveries returns.
+	maxWebhookDeliveries = 1000
+)
+
+// Validate checks req against the rules for its type, returning an
+// InvalidArgument domain error describing every violation found.
+func Validate(req interface{}) error {
+	var v violations
+
+	if msg, ok := req.(proto.Message); ok {
+		v.unknownFields("", msg.ProtoReflect())
+	}
+
+	switch r := req.(type) {
+	case *racing.ListRacesRequest:
+		validateListRacesFilter(&v, "filter", r.GetFilter())
+	case *racing.ExportRacesRequest:
+		validateListRacesFilter(&v, "filter", r.GetFilter())
+	case *racing.GetRaceRequest:
+		if r.Id <= 0 {
+			v.add("id", "must be a positive id")
+		}
+	case *racing.ListAuditEventsRequest:
+		validateListAuditEvents(&v, r)
+	case *racing.RegisterWebhookRequest:
+		validateRegisterWebhook(&v, r)
+	case *racing.ListWebhookDeliveriesRequest:
+		validateListWebhookDeliveries(&v, r)
+	case *racing.SubscribeRaceRemindersRequest:
+		if r.RaceId <= 0 {
+			v.add("race_id", "must be a positive id")
+		}
+	case *racing.UnsubscribeRaceRemindersRequest:
+		if r.RaceId <= 0 {
+			v.add("race_id", "must be a positive id")
+		}
+	case *racing.FollowRequest:
+		validateEntity(&v, r.EntityType, r.EntityId)
+	case *racing.UnfollowRequest:
+		validateEntity(&v, r.EntityType, r.EntityId)
+	case *racing.ListFollowsRequest:
+		if r.EntityType != "" && !knownEntityType(r.EntityType) {
+			v.add("entity_type", "must be one of %s", strings.Join(db.EntityTypes, ", "))
+		}
+	case *racing.ImportRacesRequest:
+		// Individual races are validated by the importer, which reports
+		// them per row rather than failing the whole import.
+		if len(r.Races) > maxImportRaces {
+			v.add("races", "must not contain more than %d races", maxImportRaces)
+		}
+	}
+
+	if len(v) > 0 {
+		return apperr.InvalidArgument(v...)
+	}
+
+	return nil
+}
+
+func validateListAuditEvents(v *violations, r *racing.ListAuditEventsRequest) {
+	if r.Limit < 0 || r.Limit > maxAuditEvents {
+		v.add("limit", "must be between 0 and %d", maxAuditEvents)
+	}
+
+	filter := r.GetFilter()
+	if filter == nil {
+		return
+	}
+
+	if filter.RaceId < 0 {
+		v.add("filter.race_id", "must be a positive id")
+	}
+
+	for _, f := range []struct {
+		name string
+		ts   *timestamppb.Timestamp
+	}{
+		{"filter.start_time", filter.StartTime},
+		{"filter.end_time", filter.EndTime},
+	} {
+		if f.ts != nil && f.ts.CheckValid() != nil {
+			v.add(f.name, "is not a valid time")
+		}
+	}
+
+	if filter.StartTime != nil && filter.EndTime != nil && !filter.StartTime.AsTime().Before(filter.EndTime.AsTime()) {
+		v.add("filter.end_time", "must be after start_time")
+	}
+}
+
+func validateRegisterWebhook(v *violations, r *racing.RegisterWebhookRequest) {
+	// The scheme is checked by the service, which knows whether insecure
+	// URLs are allowed.
+	if r.Url == "" {
+		v.add("url", "is required")
+	}
+
+	for i, t := range r.EventTypes {
+		if !events.Known(t) {
+			v.add(fmt.Sprintf("event_types[%d]", i), "must be one of %s", strings.Join(events.Types, ", "))
+		}
+	}
+}
+
+func validateListWebhookDeliveries(v *violations, r *racing.ListWebhookDeliveriesRequest) {
+	if r.WebhookId <= 0 {
+		v.add("webhook_id", "must be a positive id")
+	}
+
+	switch r.Status {
+	case "", db.DeliveryPending, db.DeliverySucceeded, db.DeliveryDeadLettered:
+	default:
+		v.add("status", "must be one of %s, %s or %s", db.DeliveryPending, db.DeliverySucceeded, db.DeliveryDeadLettered)
+	}
+
+	if r.Limit < 0 || r.Limit > maxWebhookDeliveries {
+		v.add("limit", "must be between 0 and %d", maxWebhookDeliveries)
+	}
+}
+
+func validateEntity(v *violations, entityType, entityID string) {
+	if !knownEntityType(entityType) {
+		v.add("entity_type", "must be one of %s", strings.Join(db.EntityTypes, ", "))
+	}
+
+	if err := db.ValidateEntityID(entityID); err != nil {
+		v.add("entity_id", "%s", err)
+	}
+}
+
+func knownEntityType(t string) bool {
+	for _, known := range db.EntityTypes {
+		if t == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateListRacesFilter(v *violations, path string, filter *racing.ListRacesRequestFilter) {
+	if filter == nil {
+		return
+	}
+
+	if len(filter.MeetingIds) > maxMeetingIDs {
+		v.add(path+".meeting_ids", "must not contain more than %d ids", maxMeetingIDs)
+	}
+
+	for i, id := range filter.MeetingIds {
+		if id <= 0 {
+			v.add(fmt.Sprintf("%s.meeting_ids[%d]", path, i), "must be a positive id")
+		}
+	}
+
+	if _, err := db.ParseRaceOrderBy(filter.OrderBy); err != nil {
+		v.add(path+".order_by", "%s", err)
+	}
+
+	if filter.Date != "" {
+		if err := db.ParseRaceDate(filter.Date); err != nil {
+			v.add(path+".date", "%s", err)
+		}
+	}
+
+	if filter.Timezone != "" {
+		if filter.Date == "" {
+			v.add(path+".timezone", "requires a date")
+		} else if _, err := db.LoadTimezone(filter.Timezone); err != nil {
+			v.add(path+".timezone", "must be an IANA timezone, e.g. Australia/Sydney")
+		}
+	}
+}
+
+// violations accumulates the field violations of a request.
+type violations []apperr.FieldViolation
+
+func (v *violations) add(field, format string, args ...interface{}) {
+	*v = append(*v, apperr.FieldViolation{Field: field, Description: fmt.Sprintf(format, args...)})
+}
+
+// unknownFields reports fields that are not part of the service's schema,
+// such as those sent by clients built against a newer API version, so that
+// they are never silently ignored.
+func (v *violations) unknownFields(path string, msg protoreflect.Message) {
+	for b := msg.GetUnknown(); len(b) > 0; {
+		num, _, n := protowire.ConsumeField(b)
+		if n < 0 {
+			v.add(path, "is malformed")
+			return
+		}
+
+		v.add(join(path, fmt.Sprintf("#%d", num)), "is not a known field")
+		b = b[n:]
+	}
+
+	msg.Range(func(fd protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		if fd.Kind() != protoreflect.MessageKind && fd.Kind() != protoreflect.GroupKind {
+			return true
+		}
+
+		name := join(path, string(fd.Name()))
+
+		switch {
+		case fd.IsList():
+			list := value.List()
+			for i := 0; i < list.Len(); i++ {
+				v.unknownFields(fmt.Sprintf("%s[%d]", name, i), list.Get(i).Message())
+			}
+		case fd.IsMap():
+			if fd.MapValue().Kind() == protoreflect.MessageKind {
+				value.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+					v.unknownFields(fmt.Sprintf("%s[%v]", name, k.Interface()), mv.Message())
+					return true
+				})
+			}
+		default:
+			v.unknownFields(name, value.Message())
+		}
+
+		return true
+	})
+}
+
+func join(path, field string) string {
+	if path == "" {
+		return field
+	}
+
+	return strings.Join([]string{path, field}, ".")
+}
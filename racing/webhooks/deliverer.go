@@ -0,0 +1,230 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/db"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Headers sent with each delivery.
+const (
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// batchSize bounds the number of deliveries attempted per poll.
+const batchSize = 100
+
+var attempts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "racing",
+	Subsystem: "webhooks",
+	Name:      "delivery_attempts_total",
+	Help:      "Total number of webhook delivery attempts, by outcome.",
+}, []string{"outcome"})
+
+// Config controls how deliveries are attempted.
+type Config struct {
+	// MaxAttempts is the number of attempts made before a delivery is dead
+	// lettered.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, which doubles with
+	// every failed attempt up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout bounds each attempt.
+	Timeout time.Duration
+	// PollInterval is how often due deliveries are looked for.
+	PollInterval time.Duration
+	// Policy restricts the addresses deliveries are made to.
+	Policy Policy
+}
+
+// Deliverer POSTs queued events to their webhooks. Each request is signed
+// with the webhook's secret, and failed deliveries are retried with
+// exponential backoff until they are dead lettered.
+type Deliverer struct {
+	repo   db.WebhooksRepo
+	cfg    Config
+	client *http.Client
+}
+
+// NewDeliverer returns a deliverer attempting the deliveries queued in repo.
+func NewDeliverer(repo db.WebhooksRepo, cfg Config) *Deliverer {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Deliveries are made directly, so that every address dialled is checked
+	// against the policy.
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   cfg.Policy.control,
+	}).DialContext
+
+	return &Deliverer{
+		repo: repo,
+		cfg:  cfg,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+			// Redirects are reported as failures, rather than followed to
+			// endpoints that were never registered.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Run attempts due deliveries until ctx is done.
+func (d *Deliverer) Run(ctx context.Context) {
+	for {
+		if err := d.drain(ctx); err != nil && ctx.Err() == nil {
+			zap.L().Warn("failed delivering webhooks", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.cfg.PollInterval):
+		}
+	}
+}
+
+// drain attempts deliveries until none are due.
+func (d *Deliverer) drain(ctx context.Context) error {
+	for {
+		due, err := d.repo.Due(ctx, time.Now(), batchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, delivery := range due {
+			if err := d.attempt(ctx, delivery); err != nil {
+				return err
+			}
+		}
+
+		if len(due) < batchSize {
+			return nil
+		}
+	}
+}
+
+// attempt makes the next attempt at delivery and records its outcome.
+func (d *Deliverer) attempt(ctx context.Context, delivery *db.Delivery) error {
+	attempt := db.Attempt{
+		DeliveryID:  delivery.ID,
+		Attempt:     delivery.Attempts + 1,
+		AttemptedAt: time.Now(),
+	}
+
+	code, err := d.post(ctx, delivery, attempt.AttemptedAt)
+	attempt.Duration = time.Since(attempt.AttemptedAt)
+	attempt.ResponseCode = code
+
+	status, next := db.DeliverySucceeded, attempt.AttemptedAt
+
+	switch {
+	case err == nil:
+		attempts.WithLabelValues("succeeded").Inc()
+	case attempt.Attempt >= d.cfg.MaxAttempts:
+		attempt.Error = err.Error()
+		status = db.DeliveryDeadLettered
+		attempts.WithLabelValues("dead_lettered").Inc()
+
+		zap.L().Warn("dead lettered webhook delivery",
+			zap.Int64("webhook_id", delivery.WebhookID),
+			zap.Int64("delivery_id", delivery.ID),
+			zap.Int("attempts", attempt.Attempt),
+			zap.Error(err),
+		)
+	default:
+		attempt.Error = err.Error()
+		status = db.DeliveryPending
+		next = next.Add(d.backoff(attempt.Attempt))
+		attempts.WithLabelValues("failed").Inc()
+	}
+
+	if ctx.Err() != nil {
+		// The attempt was cut short by shutdown, so it is not held against
+		// the webhook.
+		return ctx.Err()
+	}
+
+	return d.repo.RecordAttempt(ctx, attempt, status, next)
+}
+
+// post sends delivery, returning the response code, if any, and an error
+// unless the webhook accepted it.
+func (d *Deliverer) post(ctx context.Context, delivery *db.Delivery, at time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "racing-webhooks/1")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, at, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// The body is drained so that the connection may be reused.
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the retry following the given attempt,
+// jittered so that retries of many deliveries to one endpoint spread out.
+func (d *Deliverer) backoff(attempt int) time.Duration {
+	wait := d.cfg.InitialBackoff
+	for i := 1; i < attempt && wait < d.cfg.MaxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > d.cfg.MaxBackoff {
+		wait = d.cfg.MaxBackoff
+	}
+
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// Sign returns the signature header of a payload sent at the given time. The
+// signature is the hex encoded HMAC-SHA256, keyed by the webhook's secret,
+// of the time in Unix seconds and the payload, joined by a dot. Receivers
+// should recompute it, and reject old times to prevent replays.
+func Sign(secret string, at time.Time, payload []byte) string {
+	t := strconv.FormatInt(at.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(payload)
+
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
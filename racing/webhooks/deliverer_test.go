@@ -0,0 +1,276 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/events"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+)
+
+const testSecret = "whsec_test"
+
+// receiver is a webhook endpoint recording the requests it receives, and
+// answering them with status.
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+	times    []time.Time
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	rcv := &receiver{status: status}
+
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		rcv.mu.Lock()
+		rcv.requests = append(rcv.requests, r)
+		rcv.bodies = append(rcv.bodies, body)
+		rcv.times = append(rcv.times, time.Now())
+		rcv.mu.Unlock()
+
+		w.WriteHeader(rcv.status)
+	}))
+	t.Cleanup(rcv.Close)
+
+	return rcv
+}
+
+func (r *receiver) received() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.requests)
+}
+
+// newTestRepo returns a webhooks repository backed by a fresh database,
+// holding a webhook registered for url.
+func newTestRepo(t *testing.T, url string) (db.WebhooksRepo, *db.Webhook) {
+	t.Helper()
+
+	racingDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "racing.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { racingDB.Close() })
+
+	ctx := context.Background()
+	repo := db.NewWebhooksRepo(racingDB, 0)
+
+	if err := repo.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	webhook := &db.Webhook{URL: url, Secret: testSecret, Owner: "owner", CreatedAt: time.Now()}
+	if err := repo.Create(ctx, webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	return repo, webhook
+}
+
+// enqueue queues an event for delivery to webhook, returning its payload.
+func enqueue(t *testing.T, repo db.WebhooksRepo, webhook *db.Webhook) []byte {
+	t.Helper()
+
+	event := events.Event{ID: 1, Type: events.RaceCreated, RaceID: 7, OccurredAt: time.Now()}
+	payload := []byte(`{"id":1,"type":"race.created","race_id":7}`)
+
+	if err := repo.Enqueue(context.Background(), []int64{webhook.ID}, event, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	return payload
+}
+
+// testConfig allows deliveries to the receivers, which listen on loopback.
+func testConfig() Config {
+	return Config{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Timeout:        time.Second,
+		PollInterval:   time.Millisecond,
+		Policy:         Policy{AllowInsecure: true, AllowPrivate: true},
+	}
+}
+
+// deliveries returns the delivery log of webhook.
+func deliveries(t *testing.T, repo db.WebhooksRepo, webhook *db.Webhook) []*racing.WebhookDelivery {
+	t.Helper()
+
+	log, err := repo.Deliveries(context.Background(), webhook.ID, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return log
+}
+
+func TestDeliverySigned(t *testing.T) {
+	rcv := newReceiver(t, http.StatusNoContent)
+	repo, webhook := newTestRepo(t, rcv.URL)
+	payload := enqueue(t, repo, webhook)
+
+	if err := NewDeliverer(repo, testConfig()).drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if rcv.received() != 1 {
+		t.Fatalf("got %d requests, want 1", rcv.received())
+	}
+
+	req, body := rcv.requests[0], rcv.bodies[0]
+
+	if string(body) != string(payload) {
+		t.Errorf("got body %s, want %s", body, payload)
+	}
+
+	if got := req.Header.Get(EventHeader); got != events.RaceCreated {
+		t.Errorf("got event header %q, want %q", got, events.RaceCreated)
+	}
+
+	// The signature is recomputed from its documented form.
+	parts := strings.Split(req.Header.Get(SignatureHeader), ",")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "t=") || !strings.HasPrefix(parts[1], "v1=") {
+		t.Fatalf("malformed signature header %q", req.Header.Get(SignatureHeader))
+	}
+
+	ts := strings.TrimPrefix(parts[0], "t=")
+	if sent, err := strconv.ParseInt(ts, 10, 64); err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+		t.Errorf("got signature time %q, want the time of the attempt", ts)
+	}
+
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+
+	if got, want := strings.TrimPrefix(parts[1], "v1="), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("got signature %s, want %s", got, want)
+	}
+
+	log := deliveries(t, repo, webhook)
+	if len(log) != 1 || log[0].Status != db.DeliverySucceeded {
+		t.Fatalf("got delivery log %v, want a single succeeded delivery", log)
+	}
+
+	if req.Header.Get(DeliveryHeader) != strconv.FormatInt(log[0].Id, 10) {
+		t.Errorf("got delivery header %q, want %d", req.Header.Get(DeliveryHeader), log[0].Id)
+	}
+
+	if len(log[0].Attempts) != 1 || log[0].Attempts[0].ResponseCode != http.StatusNoContent || log[0].Attempts[0].Error != "" {
+		t.Errorf("got attempts %v, want a single successful attempt", log[0].Attempts)
+	}
+}
+
+func TestDeliveryRetriedUntilDeadLettered(t *testing.T) {
+	rcv := newReceiver(t, http.StatusInternalServerError)
+	repo, webhook := newTestRepo(t, rcv.URL)
+	enqueue(t, repo, webhook)
+
+	cfg := testConfig()
+	cfg.InitialBackoff = 100 * time.Millisecond
+	cfg.MaxBackoff = time.Second
+
+	d := NewDeliverer(repo, cfg)
+	deadline := time.Now().Add(5 * time.Second)
+
+	for rcv.received() < cfg.MaxAttempts && time.Now().Before(deadline) {
+		if err := d.drain(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A dead lettered delivery is never attempted again.
+	time.Sleep(2 * cfg.MaxBackoff)
+	if err := d.drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if rcv.received() != cfg.MaxAttempts {
+		t.Fatalf("got %d attempts, want %d", rcv.received(), cfg.MaxAttempts)
+	}
+
+	// Retries wait at least half of the doubling backoff, given the jitter.
+	for i, want := range []time.Duration{cfg.InitialBackoff / 2, cfg.InitialBackoff} {
+		if gap := rcv.times[i+1].Sub(rcv.times[i]); gap < want {
+			t.Errorf("got retry %d after %s, want at least %s", i+1, gap, want)
+		}
+	}
+
+	log := deliveries(t, repo, webhook)
+	if len(log) != 1 || log[0].Status != db.DeliveryDeadLettered || log[0].NextAttemptAt != nil {
+		t.Fatalf("got delivery log %v, want a single dead lettered delivery", log)
+	}
+
+	if len(log[0].Attempts) != cfg.MaxAttempts {
+		t.Fatalf("got %d logged attempts, want %d", len(log[0].Attempts), cfg.MaxAttempts)
+	}
+
+	for i, attempt := range log[0].Attempts {
+		if attempt.Attempt != int32(i+1) || attempt.ResponseCode != http.StatusInternalServerError || attempt.Error == "" {
+			t.Errorf("got attempt %v, want failed attempt %d", attempt, i+1)
+		}
+	}
+}
+
+func TestBackoffDoubles(t *testing.T) {
+	d := NewDeliverer(nil, Config{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second})
+
+	for attempt, want := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 8 * time.Second,
+		5: 10 * time.Second,
+		9: 10 * time.Second,
+	} {
+		for i := 0; i < 100; i++ {
+			if got := d.backoff(attempt); got < want/2 || got > want {
+				t.Fatalf("got backoff %s after attempt %d, want between %s and %s", got, attempt, want/2, want)
+			}
+		}
+	}
+}
+
+func TestDeliveryRefusesPrivateAddresses(t *testing.T) {
+	rcv := newReceiver(t, http.StatusNoContent)
+	repo, webhook := newTestRepo(t, rcv.URL)
+	enqueue(t, repo, webhook)
+
+	cfg := testConfig()
+	cfg.Policy.AllowPrivate = false
+
+	if err := NewDeliverer(repo, cfg).drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if rcv.received() != 0 {
+		t.Fatalf("got %d requests to a loopback address, want none", rcv.received())
+	}
+
+	log := deliveries(t, repo, webhook)
+	if len(log) != 1 || len(log[0].Attempts) != 1 || !strings.Contains(log[0].Attempts[0].Error, "private address") {
+		t.Fatalf("got delivery log %v, want an attempt refused as private", log)
+	}
+}
@@ -0,0 +1,35 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/events"
+)
+
+// Dispatcher queues race events for delivery to the webhooks subscribed to
+// them. It is a publisher, so the outbox relay retries events it fails to
+// queue, and queuing an event twice has no effect.
+type Dispatcher struct {
+	repo db.WebhooksRepo
+}
+
+// NewDispatcher returns a dispatcher queuing deliveries in repo.
+func NewDispatcher(repo db.WebhooksRepo) *Dispatcher {
+	return &Dispatcher{repo: repo}
+}
+
+func (d *Dispatcher) Publish(ctx context.Context, event events.Event) error {
+	ids, err := d.repo.Matching(ctx, event)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return d.repo.Enqueue(ctx, ids, event, payload)
+}
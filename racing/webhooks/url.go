@@ -0,0 +1,119 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+// maxURLLength bounds the length of webhook URLs.
+const maxURLLength = 2048
+
+// privateNetworks are the address ranges reserved for private networks.
+var privateNetworks = parseNetworks("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+// Policy restricts the endpoints webhooks may be registered with, and
+// delivered to.
+type Policy struct {
+	// AllowInsecure permits plain HTTP URLs.
+	AllowInsecure bool
+	// AllowPrivate permits endpoints on loopback, private, link-local and
+	// unspecified addresses, which are otherwise refused so that webhooks
+	// cannot reach services that are not exposed to the internet.
+	AllowPrivate bool
+}
+
+// ValidateURL returns an error unless raw is an absolute HTTPS URL, or HTTP
+// when insecure URLs are allowed, whose host resolves to public addresses
+// only, unless private addresses are allowed.
+func (p Policy) ValidateURL(ctx context.Context, raw string) error {
+	if len(raw) > maxURLLength {
+		return errors.New("must not be longer than 2048 characters")
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return errors.New("must be an absolute URL")
+	}
+
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && p.AllowInsecure:
+	default:
+		return errors.New("must be an https URL")
+	}
+
+	if u.User != nil {
+		return errors.New("must not contain credentials")
+	}
+
+	if p.AllowPrivate {
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return errors.New("must have a host that resolves")
+	}
+
+	for _, addr := range addrs {
+		if !public(addr.IP) {
+			return errors.New("must not resolve to a private address")
+		}
+	}
+
+	return nil
+}
+
+// control is a net.Dialer Control function refusing connections to private
+// addresses, unless they are allowed. Hosts are checked again as they are
+// dialled, as they may resolve differently than when they were registered.
+func (p Policy) control(network, address string, _ syscall.RawConn) error {
+	if p.AllowPrivate {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !public(ip) {
+		return fmt.Errorf("refusing to connect to private address %s", host)
+	}
+
+	return nil
+}
+
+// public reports whether ip is routable on the internet.
+func public(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		networks[i] = network
+	}
+
+	return networks
+}